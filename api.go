@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// HTTP JSON API
+// Served by `pvw serve --api-listen`. The endpoints return the same snapshot format as `pvw --json`, filtered down to
+// the processes and connections that were asked for, except /v1/connections which returns a flat list of connections.
+
+// The signals that can be sent with POST /v1/processes/{pid}/signal
+var apiSignals = []string{"TERM", "INT", "HUP", "QUIT", "KILL", "USR1", "USR2"}
+
+// The options for the API server
+type apiOptions struct {
+	readOnly bool   // Disables every endpoint that changes anything
+	token    string // The bearer token needed to send signals. Signals are disabled if it's empty
+}
+
+// An error, as returned by the API.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// A connection along with the process that has it open, as returned by /v1/connections
+type apiConnection struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+	User string `json:"user"`
+	jsonConnection
+}
+
+// The response from /v1/connections
+type apiConnections struct {
+	Time        time.Time       `json:"time"`
+	Connections []apiConnection `json:"connections"`
+}
+
+// writeJSON() writes a value as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// writeAPIError() writes an error as the JSON response body
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// writeProcesses() writes the processes as a snapshot, with the time they were collected
+func writeProcesses(w http.ResponseWriter, c *collector, matches func(proc process, conn connection) bool) {
+	processes, collected := c.latest()
+
+	if collected.IsZero() {
+		writeAPIError(w, http.StatusServiceUnavailable, "no processes have been collected yet")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snapshot{
+		Time:      collected,
+		Processes: toJSONProcesses(selectConnections(processes, matches)),
+	})
+}
+
+// writeConnections() writes every connection that matches as a flat list, each with the details of its process
+func writeConnections(w http.ResponseWriter, c *collector, matches func(proc process, conn connection) bool) {
+	processes, collected := c.latest()
+
+	if collected.IsZero() {
+		writeAPIError(w, http.StatusServiceUnavailable, "no processes have been collected yet")
+		return
+	}
+
+	connections := make([]apiConnection, 0)
+	for _, proc := range toJSONProcesses(selectConnections(processes, matches)) {
+		for _, conn := range proc.Connections {
+			connections = append(connections, apiConnection{
+				PID:            proc.PID,
+				Name:           proc.Name,
+				User:           proc.User,
+				jsonConnection: conn,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, apiConnections{Time: collected, Connections: connections})
+}
+
+// queryMatcher() builds a filter from the query parameters shared by /v1/processes and /v1/connections. Each
+// parameter accepts a list of values separated by commas, and any parameter that isn't set doesn't filter anything.
+func queryMatcher(r *http.Request) func(proc process, conn connection) bool {
+	query := r.URL.Query()
+
+	list := func(name string) []string {
+		if query.Get(name) == "" {
+			return nil
+		}
+		return strings.Split(query.Get(name), ",")
+	}
+	matchesList := func(values []string, value string) bool {
+		return values == nil || slices.Contains(values, value)
+	}
+
+	names, users, pids := list("name"), list("user"), list("pid")
+	states, protocols, ports := list("state"), list("protocol"), list("port")
+
+	return func(proc process, conn connection) bool {
+		return matchesList(names, proc.name) &&
+			matchesList(users, proc.username) &&
+			matchesList(pids, strconv.Itoa(proc.id)) &&
+			matchesList(states, conn.status) &&
+			matchesList(protocols, conn.protocol) &&
+			(ports == nil || slices.Contains(ports, conn.localPort) || slices.Contains(ports, conn.remotePort))
+	}
+}
+
+// apiHandler() creates the HTTP handler for every /v1/ endpoint
+func apiHandler(c *collector, options apiOptions) http.Handler {
+	mux := http.NewServeMux()
+
+	// GET /v1/processes - every process, optionally filtered by name, user or pid
+	mux.HandleFunc("/v1/processes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeProcesses(w, c, queryMatcher(r))
+	})
+
+	// GET /v1/connections - every connection, optionally filtered by state, protocol, port, name, user or pid
+	mux.HandleFunc("/v1/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeConnections(w, c, queryMatcher(r))
+	})
+
+	// GET /v1/who/{port} - every process using a local port
+	mux.HandleFunc("/v1/who/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		port := strings.TrimPrefix(r.URL.Path, "/v1/who/")
+		if _, err := strconv.Atoi(port); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid port "+port)
+			return
+		}
+
+		writeProcesses(w, c, func(proc process, conn connection) bool {
+			return conn.localPort == port
+		})
+	})
+
+	// POST /v1/processes/{pid}/signal - send a signal to a process, using the same path as terminating in the TUI
+	mux.HandleFunc("/v1/processes/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/processes/"), "/"), "/")
+		if len(path) != 2 || path[1] != "signal" {
+			writeAPIError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		if options.readOnly || options.token == "" {
+			writeAPIError(w, http.StatusForbidden, "sending signals is disabled")
+			return
+		}
+
+		// The token has to be sent as a bearer token, rather than on its own
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		isBearer := strings.HasPrefix(authorization, "Bearer ")
+		if !isBearer || subtle.ConstantTimeCompare([]byte(token), []byte(options.token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+
+		pid, err := strconv.Atoi(path[0])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid pid "+path[0])
+			return
+		}
+
+		signal := strings.TrimPrefix(strings.ToUpper(r.URL.Query().Get("signal")), "SIG")
+		if signal == "" {
+			signal = "TERM"
+		}
+		if !slices.Contains(apiSignals, signal) {
+			writeAPIError(w, http.StatusBadRequest, "unsupported signal "+signal)
+			return
+		}
+
+		// Only processes pvw can see can be signalled, so the API can't be used to kill anything else on the host
+		processes, _ := c.latest()
+		found := false
+		for _, proc := range processes {
+			if proc.id == pid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			writeAPIError(w, http.StatusNotFound, "no process with a connection open has pid "+path[0])
+			return
+		}
+
+		if err := signalProcess(pid, signal); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Collect again so the next request doesn't see a process that's gone
+		go c.collect()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
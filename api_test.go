@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiTestCollector creates a collector that has already collected a single process
+func apiTestCollector() *collector {
+	c := newCollector(settings{showIPv4: true, showIPv6: true})
+	c.processes = []process{{id: 4242, name: "nginx", username: "www-data", connections: []connection{
+		{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: "80"},
+	}}}
+	c.collected = time.Now()
+	return c
+}
+
+// fakeKill puts a kill on the PATH that writes its arguments to a file instead of signalling anything, along with an
+// lsof that doesn't find anything for the collection after a signal. Returns the file kill writes to.
+func fakeKill(t *testing.T) string {
+	bin := t.TempDir()
+	log := filepath.Join(bin, "kill.log")
+
+	scripts := map[string]string{
+		"kill": "#!/bin/sh\necho \"$@\" >> " + log + "\n",
+		"lsof": "#!/bin/sh\nexit 1\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestAPISignal(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       apiOptions
+		method        string
+		path          string
+		authorization string
+		wantStatus    int
+		wantKill      string // The arguments kill should have been run with, if it should have been
+	}{
+		{"sends TERM by default", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/4242/signal",
+			"Bearer secret", http.StatusNoContent, "-s TERM 4242"},
+		{"sends another signal", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/4242/signal?signal=sigkill",
+			"Bearer secret", http.StatusNoContent, "-s KILL 4242"},
+		{"read-only", apiOptions{token: "secret", readOnly: true}, http.MethodPost, "/v1/processes/4242/signal",
+			"Bearer secret", http.StatusForbidden, ""},
+		{"no token set", apiOptions{}, http.MethodPost, "/v1/processes/4242/signal", "Bearer ", http.StatusForbidden, ""},
+		{"no authorization", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/4242/signal", "",
+			http.StatusUnauthorized, ""},
+		{"token without bearer", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/4242/signal", "secret",
+			http.StatusUnauthorized, ""},
+		{"wrong token", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/4242/signal", "Bearer secrets",
+			http.StatusUnauthorized, ""},
+		{"bad pid", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/nginx/signal", "Bearer secret",
+			http.StatusBadRequest, ""},
+		{"pid without a connection", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/1/signal",
+			"Bearer secret", http.StatusNotFound, ""},
+		{"unsupported signal", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/4242/signal?signal=STOP",
+			"Bearer secret", http.StatusBadRequest, ""},
+		{"wrong method", apiOptions{token: "secret"}, http.MethodGet, "/v1/processes/4242/signal", "Bearer secret",
+			http.StatusMethodNotAllowed, ""},
+		{"wrong path", apiOptions{token: "secret"}, http.MethodPost, "/v1/processes/4242/kill", "Bearer secret",
+			http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		log := fakeKill(t)
+
+		request := httptest.NewRequest(test.method, test.path, nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		response := httptest.NewRecorder()
+		apiHandler(apiTestCollector(), test.options).ServeHTTP(response, request)
+
+		if response.Code != test.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", test.name, response.Code, test.wantStatus, response.Body)
+		}
+
+		killed, _ := os.ReadFile(log)
+		if got := strings.TrimSpace(string(killed)); got != test.wantKill {
+			t.Errorf("%s: kill was run with %q, want %q", test.name, got, test.wantKill)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Audit
+// `pvw audit` looks through the sockets pvw can see for things that are worth a closer look, and reports them as
+// findings with a severity, as text, JSON or SARIF.
+
+// How serious a finding is. Higher is worse.
+type severity int
+
+const (
+	severityInfo severity = iota
+	severityLow
+	severityMedium
+	severityHigh
+)
+
+// The names of the severities, as used on the command line and in reports
+var severityNames = []string{"info", "low", "medium", "high"}
+
+func (s severity) String() string { return severityNames[s] }
+
+// MarshalJSON() writes a severity as its name
+func (s severity) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// parseSeverity() gets a severity from its name
+func parseSeverity(name string) (severity, error) {
+	index := slices.Index(severityNames, strings.ToLower(name))
+	if index < 0 {
+		return 0, fmt.Errorf("unknown severity %q. Please use info, low, medium or high", name)
+	}
+	return severity(index), nil
+}
+
+// Something the audit checks for
+type auditRule struct {
+	id          string
+	severity    severity
+	description string
+}
+
+// The rules the audit checks
+var (
+	ruleMalwarePort     = auditRule{"malware-port", severityHigh, "Listening on a port used by known malware"}
+	ruleDeletedExe      = auditRule{"deleted-executable", severityHigh, "Process holding sockets has had its executable deleted"}
+	ruleRootWildcard    = auditRule{"root-wildcard-listener", severityMedium, "Process running as root is listening on every interface"}
+	ruleRawSocket       = auditRule{"unexpected-raw-socket", severityMedium, "Process that doesn't usually need one has a raw socket open"}
+	ruleUnknownHighPort = auditRule{"unknown-high-port", severityLow, "Listening on a high port, reachable from other hosts, with no known service"}
+)
+
+// Every rule, in the order findings with the same severity are reported in
+var auditRules = []auditRule{ruleMalwarePort, ruleDeletedExe, ruleRootWildcard, ruleRawSocket, ruleUnknownHighPort}
+
+// The services in serviceNames that are actually malware
+var malwareServiceNames = []string{"netbus", "sub7", "back-orifice"}
+
+// The processes that are expected to have raw sockets open, like ping
+var expectedRawProcesses = []string{"ping", "ping6", "arping", "traceroute", "dhclient", "dhcpcd", "dhcpd",
+	"NetworkManager", "systemd-networkd", "keepalived", "bird", "bird6"}
+
+// A finding from the audit
+type auditFinding struct {
+	Rule     string   `json:"rule"`
+	Severity severity `json:"severity"`
+	Message  string   `json:"message"`
+
+	PID     int    `json:"pid"`
+	Process string `json:"process"`
+	User    string `json:"user"`
+
+	Protocol string `json:"protocol,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Port     string `json:"port,omitempty"`
+}
+
+// newFinding() creates a finding for a rule, about a process and optionally one of its connections
+func newFinding(rule auditRule, proc process, conn *connection, message string) auditFinding {
+	finding := auditFinding{
+		Rule:     rule.id,
+		Severity: rule.severity,
+		Message:  message,
+		PID:      proc.id,
+		Process:  proc.name,
+		User:     proc.username,
+	}
+	if conn != nil {
+		finding.Protocol = conn.protocol
+		finding.Address = conn.localAddress
+		finding.Port = conn.localPort
+	}
+	return finding
+}
+
+// hasDeletedExecutable() checks whether a process' executable has been deleted since it started, which is how a lot
+// of malware hides itself. Only works on Linux, where the link to the executable in /proc says whether it's deleted.
+func hasDeletedExecutable(pid int) bool {
+	link, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+	return err == nil && strings.HasSuffix(link, " (deleted)")
+}
+
+// auditRawSockets() finds raw sockets owned by processes that aren't expected to have them. lsof -i doesn't show raw
+// sockets, so they're found by matching the sockets in /proc/net/raw to processes' open files. Linux only.
+func auditRawSockets(allowed []string) []auditFinding {
+	raw := make(map[string]connection)
+	for inode, conn := range readProcNet("/proc/net/raw", "RAW", false) {
+		raw[inode] = conn
+	}
+	for inode, conn := range readProcNet("/proc/net/raw6", "RAW", true) {
+		raw[inode] = conn
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var findings []auditFinding
+	usernames := make(map[string]string)
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		for _, inode := range getProcessSockets(entry.Name()) {
+			conn, isRaw := raw[inode]
+			if !isRaw {
+				continue
+			}
+
+			name, username := getProcessOwner(entry.Name(), usernames)
+			if slices.Contains(allowed, name) {
+				break
+			}
+
+			// The port of a raw socket is the IP protocol number it's for
+			conn.localPort = "proto " + conn.localPort
+			proc := process{id: pid, name: name, username: username}
+			findings = append(findings, newFinding(ruleRawSocket, proc, &conn, name+" has a raw socket open"))
+			break
+		}
+	}
+	return findings
+}
+
+// auditProcesses() runs every rule against the processes, and any rules that need to look further than them
+func auditProcesses(processes []process, allowedRaw []string) []auditFinding {
+	var findings []auditFinding
+
+	for _, proc := range processes {
+		if runtime.GOOS == "linux" && hasDeletedExecutable(proc.id) {
+			findings = append(findings, newFinding(ruleDeletedExe, proc, nil,
+				proc.name+"'s executable has been deleted, but it still has sockets open"))
+		}
+
+		for i := range proc.connections {
+			conn := &proc.connections[i]
+			if !isListening(*conn) {
+				continue
+			}
+
+			service, known := serviceNames[conn.localPort]
+			port, _ := strconv.Atoi(conn.localPort)
+
+			if known && slices.Contains(malwareServiceNames, service) {
+				findings = append(findings, newFinding(ruleMalwarePort, proc, conn,
+					fmt.Sprintf("%s is listening on %s, the port used by %s", proc.name, conn.localPort, service)))
+			}
+
+			if proc.username == "root" && classifyExposure(*conn) == exposureAll {
+				findings = append(findings, newFinding(ruleRootWildcard, proc, conn,
+					fmt.Sprintf("%s is running as root and listening on %s:%s", proc.name, conn.localAddress, conn.localPort)))
+			}
+
+			// Ephemeral UDP ports are clients waiting for replies, rather than services
+			if !known && port >= 1024 && isExposed(*conn) && !isEphemeralUDP(*conn) {
+				findings = append(findings, newFinding(ruleUnknownHighPort, proc, conn,
+					fmt.Sprintf("%s is listening on %s:%s, which isn't a known service", proc.name, conn.localAddress, conn.localPort)))
+			}
+		}
+	}
+
+	if runtime.GOOS == "linux" {
+		findings = append(findings, auditRawSockets(allowedRaw)...)
+	}
+
+	// Worst first, then in the order of the rules, then by process
+	ruleOrder := func(id string) int {
+		return slices.IndexFunc(auditRules, func(rule auditRule) bool { return rule.id == id })
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity > findings[j].Severity
+		}
+		if findings[i].Rule != findings[j].Rule {
+			return ruleOrder(findings[i].Rule) < ruleOrder(findings[j].Rule)
+		}
+		return findings[i].PID < findings[j].PID
+	})
+	return findings
+}
+
+// reportedFindings() gets the findings at or above the minimum severity, along with how many of them are at or above
+// the severity that fails the audit. Findings that aren't reported don't count towards failing either.
+func reportedFindings(all []auditFinding, minSeverity, failOn severity) ([]auditFinding, int) {
+	var findings []auditFinding
+	failing := 0
+
+	for _, finding := range all {
+		if finding.Severity < minSeverity {
+			continue
+		}
+
+		findings = append(findings, finding)
+		if finding.Severity >= failOn {
+			failing += 1
+		}
+	}
+	return findings, failing
+}
+
+// writeAuditText() prints the findings for people to read
+func writeAuditText(w io.Writer, findings []auditFinding) {
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No findings")
+		return
+	}
+
+	for _, finding := range findings {
+		fmt.Fprintf(w, "%-6s  %-22s  %s (pid %d, user %s)\n", strings.ToUpper(finding.Severity.String()), finding.Rule,
+			finding.Message, finding.PID, finding.User)
+	}
+	fmt.Fprintf(w, "\n%d findings\n", len(findings))
+}
+
+// The parts of SARIF (https://sarifweb.azurewebsites.net) pvw uses. Findings aren't about source files, so they're
+// located by process instead.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string          `json:"ruleId"`
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Locations  []sarifLocation `json:"locations"`
+	Properties auditFinding    `json:"properties"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// sarifLevels maps severities to SARIF's levels
+var sarifLevels = map[severity]string{
+	severityInfo:   "note",
+	severityLow:    "note",
+	severityMedium: "warning",
+	severityHigh:   "error",
+}
+
+// toSARIF() converts the findings to a SARIF log
+func toSARIF(findings []auditFinding) sarifLog {
+	var run sarifRun
+	run.Tool.Driver.Name = "pvw"
+	run.Tool.Driver.InformationURI = "https://github.com/allyring/pvw"
+	for _, rule := range auditRules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{rule.id, sarifMessage{rule.description}})
+	}
+
+	run.Results = make([]sarifResult, 0, len(findings))
+	for _, finding := range findings {
+		location := sarifLocation{[]sarifLogicalLocation{{processLabel(process{id: finding.PID, name: finding.Process}), "process"}}}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleID:     finding.Rule,
+			Level:      sarifLevels[finding.Severity],
+			Message:    sarifMessage{finding.Message},
+			Locations:  []sarifLocation{location},
+			Properties: finding,
+		})
+	}
+
+	return sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}
+}
+
+// runAudit() handles `pvw audit`
+func runAudit(args []string) int {
+	flags := pflag.NewFlagSet("audit", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw audit [flags]\n\nChecks the sockets pvw can see for anything suspicious. Exits with 1 if there are more findings\n"+
+			"at or above --fail-on (and --min-severity) than --max-findings, and 2 if something went wrong.")
+		flags.PrintDefaults()
+	}
+
+	flagOutput := flags.StringP("output", "o", "text", "Output format: text, json or sarif")
+	flagMinSeverity := flags.String("min-severity", "info", "Only report findings at or above this severity: info, low, medium or high")
+	flagFailOn := flags.String("fail-on", "high", "Severity that findings count towards --max-findings at or above")
+	flagMaxFindings := flags.Int("max-findings", 0, "Number of findings at or above --fail-on allowed before exiting with 1")
+	flagAllowRaw := flags.StringSlice("allow-raw", nil, "Process names allowed to have raw sockets, on top of the usual ones like ping, separated by commas")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if *flagOutput != "text" && *flagOutput != "json" && *flagOutput != "sarif" {
+		fmt.Println("Error running pvw: unknown output format " + *flagOutput)
+		return 2
+	}
+
+	minSeverity, err := parseSeverity(*flagMinSeverity)
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	failOn, err := parseSeverity(*flagFailOn)
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	processes, err := collectProcesses(settings{showIPv4: true, showIPv6: true})
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	allowedRaw := append(slices.Clone(expectedRawProcesses), *flagAllowRaw...)
+
+	findings, failing := reportedFindings(auditProcesses(processes, allowedRaw), minSeverity, failOn)
+
+	switch *flagOutput {
+	case "json", "sarif":
+		var report any = findings
+		if *flagOutput == "sarif" {
+			report = toSARIF(findings)
+		} else if findings == nil {
+			report = []auditFinding{}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+		break
+
+	default:
+		writeAuditText(os.Stdout, findings)
+		break
+	}
+
+	if failing > *flagMaxFindings {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// unknownHighPorts() gets the ports the unknown-high-port rule finds in some processes
+func unknownHighPorts(processes []process) []string {
+	var ports []string
+	for _, finding := range auditProcesses(processes, expectedRawProcesses) {
+		if finding.Rule == ruleUnknownHighPort.id {
+			ports = append(ports, finding.Protocol+"/"+finding.Port)
+		}
+	}
+	return ports
+}
+
+// unknownPortBelow() gets a port that isn't a known service, below the ephemeral range
+func unknownPortBelow(t *testing.T, limit int) string {
+	for port := limit - 1; port >= 1024; port-- {
+		if _, known := serviceNames[strconv.Itoa(port)]; !known {
+			return strconv.Itoa(port)
+		}
+	}
+	t.Fatal("every port below the ephemeral range is a known service")
+	return ""
+}
+
+func TestAuditUnknownHighPort(t *testing.T) {
+	ephemeral := strconv.Itoa(ephemeralPorts[0])
+	unknown := unknownPortBelow(t, ephemeralPorts[0])
+
+	processes := []process{{id: 2147483000, name: "app", username: "app", connections: []connection{
+		{protocol: "UDP", status: "UNCONN", localAddress: "10.0.0.1", localPort: ephemeral},
+		{protocol: "UDP", status: "UNCONN", localAddress: "10.0.0.1", localPort: unknown},
+		{protocol: "TCP", status: "LISTEN", localAddress: "10.0.0.1", localPort: ephemeral},
+		{protocol: "UDP", status: "UNCONN", localAddress: "127.0.0.1", localPort: unknown},
+	}}}
+
+	want := []string{"UDP/" + unknown, "TCP/" + ephemeral}
+	got := unknownHighPorts(processes)
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("unknown-high-port found %v, want %v", got, want)
+	}
+}
+
+func TestReportedFindings(t *testing.T) {
+	all := []auditFinding{
+		{Rule: ruleMalwarePort.id, Severity: severityHigh},
+		{Rule: ruleRootWildcard.id, Severity: severityMedium},
+		{Rule: ruleUnknownHighPort.id, Severity: severityLow},
+		{Rule: ruleUnknownHighPort.id, Severity: severityLow},
+	}
+
+	tests := []struct {
+		minSeverity, failOn severity
+		wantReported        int
+		wantFailing         int
+	}{
+		{severityInfo, severityHigh, 4, 1},
+		{severityInfo, severityLow, 4, 4},
+		{severityMedium, severityLow, 2, 2},
+		{severityHigh, severityInfo, 1, 1},
+	}
+
+	for _, test := range tests {
+		reported, failing := reportedFindings(all, test.minSeverity, test.failOn)
+		if len(reported) != test.wantReported || failing != test.wantFailing {
+			t.Errorf("--min-severity %s --fail-on %s reported %d and failed on %d, want %d and %d", test.minSeverity,
+				test.failOn, len(reported), failing, test.wantReported, test.wantFailing)
+		}
+	}
+}
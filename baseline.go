@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Baselines
+// A baseline is the list of ports a host is allowed to listen on. `pvw baseline capture` writes one from the live
+// listeners, and `pvw baseline check` reports any drift from it, so a cron job can catch ports being opened.
+
+// The format and version written in every baseline, so that files that aren't baselines get rejected
+const (
+	baselineFormat  = "pvw-baseline"
+	baselineVersion = 1
+)
+
+// A port the host is allowed to listen on. An empty address matches every address, and an empty process or user
+// matches every process or user.
+type baselineEntry struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address,omitempty"`
+	Port     string `json:"port"`
+
+	Process string `json:"process,omitempty"`
+	User    string `json:"user,omitempty"`
+}
+
+// A baseline file
+type baseline struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version"`
+	Captured time.Time       `json:"captured"`
+	Hostname string          `json:"hostname,omitempty"`
+	Entries  []baselineEntry `json:"listeners"`
+}
+
+// A listener that's owned by a different process or user than the baseline expects
+type baselineMismatch struct {
+	Expected baselineEntry `json:"expected"`
+	Actual   baselineEntry `json:"actual"`
+}
+
+// The drift between a baseline and the live listeners
+type baselineDrift struct {
+	Unexpected []baselineEntry    `json:"unexpected"`
+	Missing    []baselineEntry    `json:"missing"`
+	Mismatched []baselineMismatch `json:"mismatched"`
+}
+
+// empty() checks whether the live listeners match the baseline exactly
+func (d baselineDrift) empty() bool {
+	return len(d.Unexpected) == 0 && len(d.Missing) == 0 && len(d.Mismatched) == 0
+}
+
+// socket() describes the socket of an entry, without its owner
+func (e baselineEntry) socket() string {
+	address := e.Address
+	if address == "" {
+		address = "*"
+	}
+	return e.Protocol + " " + address + ":" + e.Port
+}
+
+// owner() describes the owner of an entry, if it has one
+func (e baselineEntry) owner() string {
+	process, user := e.Process, e.User
+	if process == "" {
+		process = "any process"
+	}
+	if user == "" {
+		user = "any user"
+	}
+	return process + " as " + user
+}
+
+// matchesSocket() checks whether a live listener is on the socket an entry allows
+func (e baselineEntry) matchesSocket(live baselineEntry) bool {
+	return strings.EqualFold(e.Protocol, live.Protocol) && e.Port == live.Port &&
+		(e.Address == "" || strings.Trim(e.Address, "[]") == strings.Trim(live.Address, "[]"))
+}
+
+// matchesOwner() checks whether a live listener is owned by the process and user an entry allows
+func (e baselineEntry) matchesOwner(live baselineEntry) bool {
+	return (e.Process == "" || e.Process == live.Process) && (e.User == "" || e.User == live.User)
+}
+
+// ephemeral() checks whether an entry is a UDP socket in the ephemeral port range, which gets a new port every time
+func (e baselineEntry) ephemeral() bool {
+	return isEphemeralUDP(connection{protocol: e.Protocol, localPort: e.Port})
+}
+
+// liveListeners() gets every listening socket as a baseline entry. When more than one process listens on the same
+// socket, the one with the lowest PID is used, like in `pvw diff`. UDP sockets in the ephemeral port range are left
+// out, since they'd be on a different port by the next check.
+func liveListeners(processes []process) []baselineEntry {
+	owners := make(map[string]int)
+	listeners := make(map[string]baselineEntry)
+
+	for _, proc := range processes {
+		for _, conn := range proc.connections {
+			if !isListening(conn) || isEphemeralUDP(conn) {
+				continue
+			}
+
+			entry := baselineEntry{
+				Protocol: conn.protocol,
+				Address:  conn.localAddress,
+				Port:     conn.localPort,
+				Process:  proc.name,
+				User:     proc.username,
+			}
+
+			if pid, exists := owners[entry.socket()]; !exists || proc.id < pid {
+				owners[entry.socket()] = proc.id
+				listeners[entry.socket()] = entry
+			}
+		}
+	}
+
+	entries := make([]baselineEntry, 0, len(listeners))
+	for _, entry := range listeners {
+		entries = append(entries, entry)
+	}
+	sortBaselineEntries(entries)
+	return entries
+}
+
+// sortBaselineEntries() sorts entries by protocol, then port, then address, so files and reports are stable
+func sortBaselineEntries(entries []baselineEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			aPort, _ := strconv.Atoi(a.Port)
+			bPort, _ := strconv.Atoi(b.Port)
+			return aPort < bPort
+		}
+		return a.Address < b.Address
+	})
+}
+
+// checkBaseline() compares the live listeners against a baseline. Ephemeral UDP sockets in the baseline, from files
+// captured before they were left out, are ignored rather than reported missing.
+func checkBaseline(base baseline, live []baselineEntry) baselineDrift {
+	drift := baselineDrift{
+		Unexpected: []baselineEntry{},
+		Missing:    []baselineEntry{},
+		Mismatched: []baselineMismatch{},
+	}
+	matched := make([]bool, len(base.Entries))
+
+	for _, listener := range live {
+		allowed := false
+		var mismatch *baselineEntry
+
+		for i, entry := range base.Entries {
+			if !entry.matchesSocket(listener) {
+				continue
+			}
+			matched[i] = true
+
+			if entry.matchesOwner(listener) {
+				allowed = true
+			} else if mismatch == nil {
+				mismatch = &base.Entries[i]
+			}
+		}
+
+		switch {
+		case allowed:
+			break
+		case mismatch != nil:
+			drift.Mismatched = append(drift.Mismatched, baselineMismatch{Expected: *mismatch, Actual: listener})
+			break
+		default:
+			drift.Unexpected = append(drift.Unexpected, listener)
+			break
+		}
+	}
+
+	for i, entry := range base.Entries {
+		if !matched[i] && !entry.ephemeral() {
+			drift.Missing = append(drift.Missing, entry)
+		}
+	}
+	return drift
+}
+
+// readBaseline() loads a baseline file, checking its format and version
+func readBaseline(path string) (baseline, error) {
+	var base baseline
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return base, err
+	}
+	if err := json.Unmarshal(data, &base); err != nil {
+		return base, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if base.Format != baselineFormat {
+		return base, fmt.Errorf("%s isn't a pvw baseline", path)
+	}
+	if base.Version > baselineVersion {
+		return base, fmt.Errorf("%s is a version %d baseline, but this version of pvw only understands up to version %d",
+			path, base.Version, baselineVersion)
+	}
+	return base, nil
+}
+
+// writeDriftText() writes a human-readable report of the drift from a baseline
+func writeDriftText(out io.Writer, drift baselineDrift) {
+	if drift.empty() {
+		fmt.Fprintln(out, "Listeners match the baseline")
+		return
+	}
+
+	if len(drift.Unexpected) > 0 {
+		fmt.Fprintln(out, "Unexpected listeners:")
+		for _, entry := range drift.Unexpected {
+			fmt.Fprintf(out, "  + %s %s\n", entry.socket(), entry.owner())
+		}
+	}
+
+	if len(drift.Missing) > 0 {
+		fmt.Fprintln(out, "Missing listeners:")
+		for _, entry := range drift.Missing {
+			fmt.Fprintf(out, "  - %s %s\n", entry.socket(), entry.owner())
+		}
+	}
+
+	if len(drift.Mismatched) > 0 {
+		fmt.Fprintln(out, "Listeners with the wrong owner:")
+		for _, mismatch := range drift.Mismatched {
+			fmt.Fprintf(out, "  ~ %s: expected %s, found %s\n", mismatch.Actual.socket(), mismatch.Expected.owner(),
+				mismatch.Actual.owner())
+		}
+	}
+}
+
+// runBaseline() handles `pvw baseline`, which has its own capture and check subcommands
+func runBaseline(args []string) int {
+	usage := "Usage: pvw baseline capture [flags] FILE\n       pvw baseline check [flags] FILE"
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "capture":
+		return runBaselineCapture(args[1:])
+	case "check":
+		return runBaselineCheck(args[1:])
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// runBaselineCapture() handles `pvw baseline capture`
+func runBaselineCapture(args []string) int {
+	flags := pflag.NewFlagSet("baseline capture", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw baseline capture [flags] FILE\n\nWrites the live listeners to a baseline file, or to stdout if FILE is -.")
+		flags.PrintDefaults()
+	}
+
+	flagAnyOwner := flags.Bool("any-owner", false, "Don't record the process and user of each listener, so any owner is allowed")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	processes, err := collectProcesses(settings{showIPv4: true, showIPv6: true})
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	hostname, _ := os.Hostname()
+	base := baseline{
+		Format:   baselineFormat,
+		Version:  baselineVersion,
+		Captured: time.Now(),
+		Hostname: hostname,
+		Entries:  liveListeners(processes),
+	}
+
+	if *flagAnyOwner {
+		for i := range base.Entries {
+			base.Entries[i].Process = ""
+			base.Entries[i].User = ""
+		}
+	}
+
+	out := os.Stdout
+	if flags.Arg(0) != "-" {
+		if out, err = os.Create(flags.Arg(0)); err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+		defer out.Close()
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(base); err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	return 0
+}
+
+// runBaselineCheck() handles `pvw baseline check`. Exits with 0 when the listeners match the baseline, 1 when they've
+// drifted from it, and 2 on errors.
+func runBaselineCheck(args []string) int {
+	flags := pflag.NewFlagSet("baseline check", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw baseline check [flags] FILE\n\nCompares the live listeners against a baseline file. Exits with 0 if they match,\n"+
+			"1 if they don't, and 2 if something went wrong.")
+		flags.PrintDefaults()
+	}
+
+	flagOutput := flags.StringP("output", "o", "text", "Output format: text or json")
+	flagQuiet := flags.BoolP("quiet", "q", false, "Don't print anything, only set the exit code")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if *flagOutput != "text" && *flagOutput != "json" {
+		fmt.Println("Error running pvw: unknown output format " + *flagOutput)
+		return 2
+	}
+
+	base, err := readBaseline(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	processes, err := collectProcesses(settings{showIPv4: true, showIPv6: true})
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	drift := checkBaseline(base, liveListeners(processes))
+
+	if !*flagQuiet {
+		switch *flagOutput {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(drift); err != nil {
+				fmt.Println("Error running pvw: ", err)
+				return 2
+			}
+			break
+
+		default:
+			writeDriftText(os.Stdout, drift)
+			break
+		}
+	}
+
+	if drift.empty() {
+		return 0
+	}
+	return 1
+}
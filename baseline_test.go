@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// A DNS lookup or QUIC client binds a UDP socket on a random port, which shouldn't make the next check drift
+func TestBaselineEphemeralUDP(t *testing.T) {
+	// processesOn() gets a host listening on SSH and DNS, with a client bound to a UDP port in the ephemeral range
+	processesOn := func(port int) []process {
+		return []process{
+			{id: 100, name: "sshd", username: "root", connections: []connection{
+				{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: "22"},
+			}},
+			{id: 200, name: "systemd-resolve", username: "systemd-resolve", connections: []connection{
+				{protocol: "UDP", status: "UNCONN", localAddress: "127.0.0.53", localPort: "53"},
+			}},
+			{id: 300, name: "curl", username: "alice", connections: []connection{
+				{protocol: "UDP", status: "UNCONN", localAddress: "0.0.0.0", localPort: strconv.Itoa(port)},
+			}},
+		}
+	}
+
+	captured := liveListeners(processesOn(ephemeralPorts[0]))
+	want := []baselineEntry{
+		{Protocol: "TCP", Address: "*", Port: "22", Process: "sshd", User: "root"},
+		{Protocol: "UDP", Address: "127.0.0.53", Port: "53", Process: "systemd-resolve", User: "systemd-resolve"},
+	}
+	if !reflect.DeepEqual(captured, want) {
+		t.Fatalf("captured %+v, want %+v", captured, want)
+	}
+
+	// The client is on a different port by the next check
+	base := baseline{Format: baselineFormat, Version: baselineVersion, Entries: captured}
+	if drift := checkBaseline(base, liveListeners(processesOn(ephemeralPorts[1]))); !drift.empty() {
+		t.Errorf("check after a new ephemeral port drifted: %+v", drift)
+	}
+
+	// A baseline captured before ephemeral ports were left out doesn't report them missing
+	base.Entries = append(base.Entries, baselineEntry{Protocol: "UDP", Address: "0.0.0.0",
+		Port: strconv.Itoa(ephemeralPorts[0]), Process: "curl", User: "alice"})
+	if drift := checkBaseline(base, liveListeners(processesOn(ephemeralPorts[1]))); !drift.empty() {
+		t.Errorf("check against an old baseline drifted: %+v", drift)
+	}
+
+	// A TCP listener in the ephemeral range still counts
+	processes := processesOn(ephemeralPorts[0])
+	processes[2].connections[0].protocol, processes[2].connections[0].status = "TCP", "LISTEN"
+	if drift := checkBaseline(base, liveListeners(processes)); len(drift.Unexpected) != 1 {
+		t.Errorf("check with a TCP listener in the ephemeral range found %+v, want it unexpected", drift)
+	}
+}
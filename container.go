@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Containers
+// Works out which container a process is running in from its cgroups, and looks up the container's name from
+// whichever container runtime is available. All of this relies on /proc, so it only works on Linux.
+
+// Container IDs are 64 hex characters in every runtime pvw knows about (docker, podman, containerd and CRI-O), and
+// appear somewhere in the cgroup paths of every process in the container
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Where docker and podman keep their state, and docker's API socket
+const (
+	dockerStateDirectory = "/var/lib/docker/containers"
+	dockerSocket         = "/var/run/docker.sock"
+)
+
+// Podman keeps a list of containers in a different place for root and rootless containers
+var podmanContainerLists = []string{
+	"/var/lib/containers/storage/overlay-containers/containers.json",
+	filepath.Join(os.Getenv("HOME"), ".local/share/containers/storage/overlay-containers/containers.json"),
+}
+
+// Container names never change for a container ID, so they're cached for as long as pvw is running. IDs without a
+// name are cached too, so the runtimes don't get asked about them on every refresh.
+var containerNameCache = struct {
+	sync.Mutex
+	names map[string]string
+}{names: make(map[string]string)}
+
+// readCgroupPaths() gets the cgroup path of a process for each of its hierarchies, from /proc/<pid>/cgroup. The paths
+// are keyed by the hierarchy's controllers, which is empty for the unified (cgroup v2) hierarchy.
+func readCgroupPaths(pid int) map[string]string {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cgroup")
+	if err != nil {
+		return nil
+	}
+
+	paths := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// Each line is hierarchy-ID:controllers:path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) == 3 {
+			paths[fields[1]] = fields[2]
+		}
+	}
+	return paths
+}
+
+// getContainerID() gets the ID of the container a process is running in, or an empty string if it isn't in one
+func getContainerID(pid int) string {
+	for _, path := range readCgroupPaths(pid) {
+		if id := containerIDPattern.FindString(path); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// The HTTP client that talks to docker's API socket. There's only one, so its connections to the socket get reused.
+var dockerClient = &http.Client{
+	Timeout: time.Second,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", dockerSocket)
+		},
+	},
+}
+
+// A running container, as listed by docker's API
+type dockerContainer struct {
+	ID              string   `json:"Id"`
+	Names           []string `json:"Names"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Docker's list of running containers, which is only fetched once per refresh however many docker-proxy processes
+// there are. Unlike names, it changes whenever a container starts or stops, so it's forgotten at the start of each one.
+var dockerContainers = struct {
+	sync.Mutex
+	list   []dockerContainer
+	loaded bool
+}{}
+
+// dockerGet() makes a request to docker's API and decodes the JSON response
+func dockerGet(path string, value any) error {
+	response, err := dockerClient.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return os.ErrNotExist
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// lookupContainerName() asks each container runtime for the name of a container, in order of how cheap it is to ask
+func lookupContainerName(id string) string {
+	// Docker's state directory, which is only readable by root
+	var dockerConfig struct {
+		Name string `json:"Name"`
+	}
+	if data, err := os.ReadFile(filepath.Join(dockerStateDirectory, id, "config.v2.json")); err == nil {
+		if json.Unmarshal(data, &dockerConfig) == nil && dockerConfig.Name != "" {
+			return strings.TrimPrefix(dockerConfig.Name, "/")
+		}
+	}
+
+	// Podman's list of containers
+	for _, path := range podmanContainerLists {
+		var containers []struct {
+			ID    string   `json:"id"`
+			Names []string `json:"names"`
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil || json.Unmarshal(data, &containers) != nil {
+			continue
+		}
+		for _, container := range containers {
+			if container.ID == id && len(container.Names) > 0 {
+				return container.Names[0]
+			}
+		}
+	}
+
+	// Docker's API, which works for anyone in the docker group
+	if err := dockerGet("/containers/"+id+"/json", &dockerConfig); err == nil {
+		return strings.TrimPrefix(dockerConfig.Name, "/")
+	}
+
+	return ""
+}
+
+// getContainerName() gets the name of a container, using the cache if it's been looked up before
+func getContainerName(id string) string {
+	containerNameCache.Lock()
+	defer containerNameCache.Unlock()
+
+	if name, exists := containerNameCache.names[id]; exists {
+		return name
+	}
+
+	name := lookupContainerName(id)
+	containerNameCache.names[id] = name
+	return name
+}
+
+// forgetDockerContainers() clears the list of running containers, so the next refresh asks docker for it again
+func forgetDockerContainers() {
+	dockerContainers.Lock()
+	defer dockerContainers.Unlock()
+
+	dockerContainers.list = nil
+	dockerContainers.loaded = false
+}
+
+// listDockerContainers() gets docker's list of running containers, asking docker for it if it hasn't been this
+// refresh. A failed request isn't tried again until the next refresh either.
+func listDockerContainers() []dockerContainer {
+	dockerContainers.Lock()
+	defer dockerContainers.Unlock()
+
+	if !dockerContainers.loaded {
+		dockerContainers.list = nil
+		if err := dockerGet("/containers/json", &dockerContainers.list); err != nil {
+			dockerContainers.list = nil
+		}
+		dockerContainers.loaded = true
+	}
+	return dockerContainers.list
+}
+
+// getDockerProxyContainer() works out which container a docker-proxy process forwards to. docker-proxy runs on the
+// host rather than in the container, so it has to be matched up by the container IP in its arguments.
+func getDockerProxyContainer(pid int) (string, string) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return "", ""
+	}
+
+	args := strings.Split(string(data), "\x00")
+	containerIP := ""
+	for i, arg := range args[:len(args)-1] {
+		if arg == "-container-ip" {
+			containerIP = args[i+1]
+		}
+	}
+	if containerIP == "" {
+		return "", ""
+	}
+
+	for _, container := range listDockerContainers() {
+		for _, network := range container.NetworkSettings.Networks {
+			if network.IPAddress == containerIP || network.GlobalIPv6Address == containerIP {
+				name := ""
+				if len(container.Names) > 0 {
+					name = strings.TrimPrefix(container.Names[0], "/")
+				}
+				return container.ID, name
+			}
+		}
+	}
+	return "", ""
+}
+
+// getContainer() gets the ID and name of the container a process belongs to
+func getContainer(pid int, name string) (string, string) {
+	if name == "docker-proxy" {
+		if id, containerName := getDockerProxyContainer(pid); id != "" {
+			return id, containerName
+		}
+	}
+
+	id := getContainerID(pid)
+	if id == "" {
+		return "", ""
+	}
+	return id, getContainerName(id)
+}
+
+// containerLabel() gets the text used for a process' container in the table: its name if it has one, or else its
+// ID shortened to 12 characters, like docker does
+func containerLabel(proc process) string {
+	if proc.containerName != "" {
+		return proc.containerName
+	}
+	if len(proc.containerID) > 12 {
+		return proc.containerID[:12]
+	}
+	return proc.containerID
+}
+
+// matchesContainerFilter() checks whether a process is in one of the containers in the container filter. Containers
+// can be given by name, or by any prefix of their ID.
+func matchesContainerFilter(proc process, options settings) bool {
+	if len(options.containerFilter) == 0 {
+		return true
+	}
+
+	for _, container := range options.containerFilter {
+		if proc.containerID != "" && (container == proc.containerName || strings.HasPrefix(proc.containerID, container)) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupByContainer() sorts processes so that the ones in the same container are next to each other, with processes
+// that aren't in a container first
+func groupByContainer(processes []process) {
+	sort.SliceStable(processes, func(i, j int) bool {
+		return containerLabel(processes[i]) < containerLabel(processes[j])
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Diffing
+// `pvw diff` compares two JSON snapshots (from `pvw --json`) and reports what changed between them. Only listening
+// sockets are compared, as outgoing connections come and go far too often for a diff of them to be useful.
+
+// A listening socket, along with the process that owns it.
+type diffListener struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     string `json:"port"`
+
+	PID     int    `json:"pid"`
+	Process string `json:"process"`
+	User    string `json:"user"`
+}
+
+// A process that's listening on a different set of ports. Processes are matched by name rather than PID, as PIDs
+// almost always change when a service is restarted.
+type diffPortChange struct {
+	Process string   `json:"process"`
+	Before  []string `json:"before"`
+	After   []string `json:"after"`
+}
+
+// A listening socket that's owned by a different process or user.
+type diffOwnerChange struct {
+	Before diffListener `json:"before"`
+	After  diffListener `json:"after"`
+}
+
+// The differences between two snapshots.
+type snapshotDiff struct {
+	Added        []diffListener    `json:"added"`
+	Removed      []diffListener    `json:"removed"`
+	PortsChanged []diffPortChange  `json:"portsChanged"`
+	OwnerChanged []diffOwnerChange `json:"ownerChanged"`
+}
+
+// empty() checks whether there are no differences at all
+func (d snapshotDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.PortsChanged) == 0 && len(d.OwnerChanged) == 0
+}
+
+// key() identifies a listening socket regardless of who owns it
+func (l diffListener) key() string {
+	return l.Protocol + " " + l.Address + ":" + l.Port
+}
+
+// owner() describes the process that owns a listening socket
+func (l diffListener) owner() string {
+	return fmt.Sprintf("%s (pid %d, user %s)", l.Process, l.PID, l.User)
+}
+
+// snapshotListeners() gets every listening socket in a snapshot. When more than one process listens on the same
+// socket (like a server's worker processes), the one with the lowest PID is treated as the owner.
+func snapshotListeners(snap snapshot) map[string]diffListener {
+	listeners := make(map[string]diffListener)
+
+	for _, proc := range fromJSONProcesses(snap.Processes) {
+		for _, conn := range proc.connections {
+			if !isListening(conn) {
+				continue
+			}
+
+			l := diffListener{
+				Protocol: conn.protocol,
+				Address:  conn.localAddress,
+				Port:     conn.localPort,
+				PID:      proc.id,
+				Process:  proc.name,
+				User:     proc.username,
+			}
+
+			if existing, exists := listeners[l.key()]; !exists || l.PID < existing.PID {
+				listeners[l.key()] = l
+			}
+		}
+	}
+
+	return listeners
+}
+
+// listeningPorts() gets the sorted protocol/port pairs each process name is listening on
+func listeningPorts(snap snapshot) map[string][]string {
+	ports := make(map[string][]string)
+
+	for _, proc := range fromJSONProcesses(snap.Processes) {
+		for _, conn := range proc.connections {
+			if !isListening(conn) {
+				continue
+			}
+
+			port := conn.protocol + "/" + conn.localPort
+			if !slices.Contains(ports[proc.name], port) {
+				ports[proc.name] = append(ports[proc.name], port)
+			}
+		}
+	}
+
+	for name := range ports {
+		sort.Strings(ports[name])
+	}
+	return ports
+}
+
+// diffSnapshots() compares the listening sockets in two snapshots
+func diffSnapshots(before, after snapshot) snapshotDiff {
+	diff := snapshotDiff{
+		Added:        []diffListener{},
+		Removed:      []diffListener{},
+		PortsChanged: []diffPortChange{},
+		OwnerChanged: []diffOwnerChange{},
+	}
+
+	beforeListeners := snapshotListeners(before)
+	afterListeners := snapshotListeners(after)
+
+	for key, l := range afterListeners {
+		previous, existed := beforeListeners[key]
+		if !existed {
+			diff.Added = append(diff.Added, l)
+		} else if previous.Process != l.Process || previous.User != l.User {
+			diff.OwnerChanged = append(diff.OwnerChanged, diffOwnerChange{Before: previous, After: l})
+		}
+	}
+
+	for key, l := range beforeListeners {
+		if _, exists := afterListeners[key]; !exists {
+			diff.Removed = append(diff.Removed, l)
+		}
+	}
+
+	// Only compare processes that exist in both snapshots. Ones that appeared or disappeared are already covered by
+	// the added and removed listeners.
+	beforePorts := listeningPorts(before)
+	afterPorts := listeningPorts(after)
+
+	for name, ports := range afterPorts {
+		previous, existed := beforePorts[name]
+		if existed && !slices.Equal(previous, ports) {
+			diff.PortsChanged = append(diff.PortsChanged, diffPortChange{Process: name, Before: previous, After: ports})
+		}
+	}
+
+	// Maps don't have an order, so sort everything to keep the output stable
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].key() < diff.Added[j].key() })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].key() < diff.Removed[j].key() })
+	sort.Slice(diff.PortsChanged, func(i, j int) bool { return diff.PortsChanged[i].Process < diff.PortsChanged[j].Process })
+	sort.Slice(diff.OwnerChanged, func(i, j int) bool {
+		return diff.OwnerChanged[i].After.key() < diff.OwnerChanged[j].After.key()
+	})
+
+	return diff
+}
+
+// writeDiffText() writes a human-readable version of a diff
+func writeDiffText(out io.Writer, diff snapshotDiff) {
+	if diff.empty() {
+		fmt.Fprintln(out, "No differences")
+		return
+	}
+
+	if len(diff.Added) > 0 {
+		fmt.Fprintln(out, "Listeners added:")
+		for _, l := range diff.Added {
+			fmt.Fprintf(out, "  + %s %s\n", l.key(), l.owner())
+		}
+	}
+
+	if len(diff.Removed) > 0 {
+		fmt.Fprintln(out, "Listeners removed:")
+		for _, l := range diff.Removed {
+			fmt.Fprintf(out, "  - %s %s\n", l.key(), l.owner())
+		}
+	}
+
+	if len(diff.PortsChanged) > 0 {
+		fmt.Fprintln(out, "Processes with changed ports:")
+		for _, change := range diff.PortsChanged {
+			fmt.Fprintf(out, "  ~ %s: %s -> %s\n", change.Process,
+				strings.Join(change.Before, ", "), strings.Join(change.After, ", "))
+		}
+	}
+
+	if len(diff.OwnerChanged) > 0 {
+		fmt.Fprintln(out, "Listener owners changed:")
+		for _, change := range diff.OwnerChanged {
+			fmt.Fprintf(out, "  ~ %s: %s -> %s\n", change.After.key(), change.Before.owner(), change.After.owner())
+		}
+	}
+}
+
+// runDiff() handles `pvw diff`. Exits with 0 when the snapshots match, 1 when they differ, and 2 on errors, like diff(1).
+func runDiff(args []string) int {
+	flags := pflag.NewFlagSet("diff", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw diff [flags] BEFORE AFTER\n\nCompares the listening ports in two snapshots made with `pvw --json`.\n"+
+			"Exits with 0 if they match, 1 if they differ, and 2 if something went wrong.")
+		flags.PrintDefaults()
+	}
+
+	flagOutput := flags.StringP("output", "o", "text", "Output format: text or json")
+	flagQuiet := flags.BoolP("quiet", "q", false, "Don't print anything, only set the exit code")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	if *flagOutput != "text" && *flagOutput != "json" {
+		fmt.Println("Error running pvw: unknown output format " + *flagOutput)
+		return 2
+	}
+
+	before, err := readSnapshot(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	after, err := readSnapshot(flags.Arg(1))
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	diff := diffSnapshots(before, after)
+
+	if !*flagQuiet {
+		switch *flagOutput {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(diff); err != nil {
+				fmt.Println("Error running pvw: ", err)
+				return 2
+			}
+			break
+
+		default:
+			writeDiffText(os.Stdout, diff)
+			break
+		}
+	}
+
+	if diff.empty() {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Exposure
+// Works out who can reach a listening socket from the address it's bound to. Something listening on every interface
+// is a lot more exposed than something only listening on loopback, even though the table shows them the same way.
+
+// How exposed a listening socket is
+type exposure string
+
+const (
+	exposureNone      exposure = ""          // Not a listening socket
+	exposureLoopback  exposure = "loopback"  // Only reachable from this host
+	exposureInterface exposure = "interface" // Reachable on one of this host's interfaces
+	exposureAll       exposure = "all"       // Reachable on every interface
+)
+
+// isListening() checks whether a connection is waiting for other hosts to connect to it. That's any TCP socket in
+// LISTEN, and any UDP socket that isn't connected to a remote address.
+func isListening(conn connection) bool {
+	if conn.status == "LISTEN" {
+		return true
+	}
+	return strings.EqualFold(conn.protocol, "UDP") && conn.remoteAddress == ""
+}
+
+// The range of ports the kernel picks from for sockets that don't ask for a port. Linux's comes from
+// /proc/sys/net/ipv4/ip_local_port_range, and everywhere else uses IANA's dynamic range.
+var ephemeralPorts = readEphemeralPorts()
+
+// readEphemeralPorts() gets the lowest and highest ephemeral ports
+func readEphemeralPorts() [2]int {
+	ports := [2]int{49152, 65535}
+
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return ports
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return ports
+	}
+
+	low, lowErr := strconv.Atoi(fields[0])
+	high, highErr := strconv.Atoi(fields[1])
+	if lowErr != nil || highErr != nil || low > high {
+		return ports
+	}
+	return [2]int{low, high}
+}
+
+// isEphemeralUDP() checks whether a connection is a UDP socket on a port the kernel picked for it, like the ones DNS
+// lookups and QUIC clients use. They count as listening, but come and go with every request, so they're left out of
+// anything that expects listening ports to stay put.
+func isEphemeralUDP(conn connection) bool {
+	if !strings.EqualFold(conn.protocol, "UDP") {
+		return false
+	}
+	port, err := strconv.Atoi(conn.localPort)
+	return err == nil && port >= ephemeralPorts[0] && port <= ephemeralPorts[1]
+}
+
+// isLoopbackAddress() checks whether an address can only be reached from this host
+func isLoopbackAddress(address string) bool {
+	address = strings.Trim(address, "[]")
+	if address == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// classifyExposure() works out how exposed a connection is, if it's listening
+func classifyExposure(conn connection) exposure {
+	if !isListening(conn) {
+		return exposureNone
+	}
+
+	switch {
+	case isWildcardAddress(conn.localAddress):
+		return exposureAll
+	case isLoopbackAddress(conn.localAddress):
+		return exposureLoopback
+	default:
+		return exposureInterface
+	}
+}
+
+// isExposed() checks whether a connection is listening somewhere other hosts can reach
+func isExposed(conn connection) bool {
+	level := classifyExposure(conn)
+	return level == exposureAll || level == exposureInterface
+}
+
+// rowExposures() gets the exposure of every row in the table, in the same order as the rows
+func rowExposures(processes []process) []exposure {
+	var levels []exposure
+	for _, proc := range processes {
+		for _, conn := range proc.connections {
+			levels = append(levels, classifyExposure(conn))
+		}
+	}
+	return levels
+}
+
+// colourExposure() colours the Exposure column of a rendered table, using the theme's colours. The table measures the
+// escape codes that colour text as if they were text, so the cells are left plain and coloured after it's rendered.
+// Like colourRows(), the highlighted row keeps the table's own style.
+func colourExposure(view string, columns []table.Column, levels []exposure, scroll tableScroll, cursor int,
+	colours theme) string {
+	// Every cell is padded by a space on either side
+	left := 0
+	for i, column := range columns {
+		if column.Title == "Exposure" {
+			break
+		}
+		if i == len(columns)-1 {
+			return view
+		}
+		left += column.Width + 2
+	}
+	left++
+
+	// The table starts with its header and the line under it
+	lines := strings.Split(view, "\n")
+	for i := 2; i < len(lines); i++ {
+		row := scroll.offset + i - 2
+		if row >= len(levels) || row == cursor || levels[row] == exposureNone {
+			continue
+		}
+
+		start := visibleIndex(lines[i], left)
+		level := string(levels[row])
+		if !strings.HasPrefix(lines[i][start:], level) {
+			continue
+		}
+
+		coloured := lipgloss.NewStyle().Foreground(lipgloss.Color(colours.Exposure[levels[row]])).Render(level)
+		lines[i] = lines[i][:start] + coloured + lines[i][start+len(level):]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// visibleIndex() gets the index in a line of the character shown at a column, skipping over escape codes
+func visibleIndex(line string, column int) int {
+	shown := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			// An escape code runs up to the letter that ends it
+			end := strings.IndexFunc(line[i+1:], func(r rune) bool { return r >= '@' && r <= '~' && r != '[' })
+			if end < 0 {
+				return len(line)
+			}
+			i += end + 2
+			continue
+		}
+
+		if shown == column {
+			return i
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		shown += lipgloss.Width(string(r))
+		i += size
+	}
+	return len(line)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/table"
+)
+
+func TestClassifyExposure(t *testing.T) {
+	tests := []struct {
+		conn connection
+		want exposure
+	}{
+		{connection{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: "80"}, exposureAll},
+		{connection{protocol: "TCP", status: "LISTEN", localAddress: "[::]", localPort: "80"}, exposureAll},
+		{connection{protocol: "TCP", status: "LISTEN", localAddress: "127.0.0.1", localPort: "80"}, exposureLoopback},
+		{connection{protocol: "TCP", status: "LISTEN", localAddress: "[::1]", localPort: "80"}, exposureLoopback},
+		{connection{protocol: "TCP", status: "LISTEN", localAddress: "10.0.0.1", localPort: "80"}, exposureInterface},
+		{connection{protocol: "UDP", status: "UNCONN", localAddress: "10.0.0.1", localPort: "53"}, exposureInterface},
+		{connection{protocol: "TCP", status: "ESTABLISHED", localAddress: "10.0.0.1", localPort: "80",
+			remoteAddress: "10.0.0.2", remotePort: "5000"}, exposureNone},
+	}
+
+	for _, test := range tests {
+		if got := classifyExposure(test.conn); got != test.want {
+			t.Errorf("classifyExposure(%s %s:%s) = %q, want %q", test.conn.protocol, test.conn.localAddress,
+				test.conn.localPort, got, test.want)
+		}
+	}
+}
+
+func TestVisibleIndex(t *testing.T) {
+	tests := []struct {
+		line   string
+		column int
+		want   int
+	}{
+		{" 80   all", 6, 6},
+		{"\x1b[1m 80\x1b[0m   all", 6, 14},
+		{"\x1b[38;5;203m→\x1b[0m all", 2, 19},
+		{"short", 10, 5},
+	}
+
+	for _, test := range tests {
+		if got := visibleIndex(test.line, test.column); got != test.want {
+			t.Errorf("visibleIndex(%q, %d) = %d, want %d", test.line, test.column, got, test.want)
+		}
+	}
+}
+
+// The exposure cells are plain text, so the longest level fits in the column without being cut off
+func TestExposureColumn(t *testing.T) {
+	columns := []table.Column{{Title: "Port", Width: 5}, {Title: "Exposure", Width: 9}}
+	processes := []process{{id: 1, name: "nginx", connections: []connection{
+		{protocol: "TCP", status: "LISTEN", localAddress: "10.0.0.1", localPort: "80"},
+		{protocol: "TCP", status: "LISTEN", localAddress: "127.0.0.1", localPort: "8080"},
+	}}}
+
+	rows, _, err := formatLsof(processes, settings{columns: columns, theme: themes["dark"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := table.New(table.WithColumns(columns), table.WithRows(rows), table.WithHeight(4),
+		table.WithStyles(themes["dark"].tableStyles()))
+
+	view := colourExposure(tbl.View(), columns, rowExposures(processes), tableScroll{}, -1, themes["dark"])
+	for _, level := range []string{"interface", "loopback"} {
+		if !strings.Contains(view, level) {
+			t.Errorf("%q isn't shown whole in the table:\n%s", level, view)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/pflag"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Free ports
+// `pvw free-port` finds ports nothing is using, and `pvw can-bind` explains why binding to one would fail.
+
+// parsePortRange() parses a range of ports like 8000-8999, or a single port
+func parsePortRange(portRange string) (int, int, error) {
+	first, last, isRange := strings.Cut(portRange, "-")
+	if !isRange {
+		last = first
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+	end, err := strconv.Atoi(last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+
+	if start < 1 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+	return start, end, nil
+}
+
+// addressesOverlap() checks whether two local addresses would conflict when bound to the same port. A wildcard
+// conflicts with everything (assuming IPv6 wildcards are dual-stack, which they are by default).
+func addressesOverlap(a, b string) bool {
+	if isWildcardAddress(a) || isWildcardAddress(b) || a == "" || b == "" {
+		return true
+	}
+	return strings.Trim(a, "[]") == strings.Trim(b, "[]")
+}
+
+// bindConflicts() gets every connection that would conflict with binding to an address and port
+func bindConflicts(processes []process, protocol, address, port string) []process {
+	return selectConnections(processes, func(proc process, conn connection) bool {
+		return strings.EqualFold(conn.protocol, protocol) && conn.localPort == port &&
+			addressesOverlap(conn.localAddress, address)
+	})
+}
+
+// tryBind() binds to an address and port, then closes the socket straight away
+func tryBind(protocol, address string, port int) error {
+	hostPort := net.JoinHostPort(address, strconv.Itoa(port))
+
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp", hostPort)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	listener, err := net.Listen("tcp", hostPort)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// runFreePort() handles `pvw free-port`
+func runFreePort(args []string) int {
+	flags := pflag.NewFlagSet("free-port", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw free-port [flags]\n\nPrints ports that nothing is using, one per line.")
+		flags.PrintDefaults()
+	}
+
+	flagRange := flags.String("range", "1024-65535", "Range of ports to look in")
+	flagProtocol := flags.String("proto", "tcp", "Protocol the port needs to be free for: tcp or udp")
+	flagAddress := flags.String("address", "", "Address the port needs to be free on. Leave empty for every interface")
+	flagCount := flags.Int("count", 1, "Number of free ports to find")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	start, end, err := parsePortRange(*flagRange)
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	protocol := strings.ToLower(*flagProtocol)
+	if protocol != "tcp" && protocol != "udp" {
+		fmt.Println("Error running pvw: unknown protocol " + *flagProtocol)
+		return 2
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	processes, err := collectProcesses(settings{showIPv4: true, showIPv6: true})
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	found := 0
+	for port := start; port <= end && found < *flagCount; port++ {
+		// Skip anything lsof says is in use, then make sure by binding to it. Binding catches the sockets lsof can't
+		// see, like ones owned by other users when not running as root.
+		if len(bindConflicts(processes, protocol, *flagAddress, strconv.Itoa(port))) > 0 {
+			continue
+		}
+		if tryBind(protocol, *flagAddress, port) != nil {
+			continue
+		}
+
+		fmt.Println(port)
+		found += 1
+	}
+
+	if found < *flagCount {
+		fmt.Fprintf(os.Stderr, "Only found %d of %d free ports in %s\n", found, *flagCount, *flagRange)
+		return 1
+	}
+	return 0
+}
+
+// runCanBind() handles `pvw can-bind`
+func runCanBind(args []string) int {
+	flags := pflag.NewFlagSet("can-bind", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw can-bind [flags] [ADDRESS:]PORT\n\nChecks whether a port can be bound to, and explains why not if it can't.\n"+
+			"Exits with 0 if it can, 1 if it can't, and 2 if something went wrong.")
+		flags.PrintDefaults()
+	}
+
+	flagProtocol := flags.String("proto", "tcp", "Protocol to bind with: tcp or udp")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	protocol := strings.ToLower(*flagProtocol)
+	if protocol != "tcp" && protocol != "udp" {
+		fmt.Println("Error running pvw: unknown protocol " + *flagProtocol)
+		return 2
+	}
+
+	address, port := "", flags.Arg(0)
+	if strings.Contains(port, ":") {
+		var err error
+		if address, port, err = net.SplitHostPort(port); err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		if port, err = lookupServicePort(port); err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+		portNumber, _ = strconv.Atoi(port)
+	}
+
+	target := strings.ToUpper(protocol) + " " + net.JoinHostPort(address, port)
+
+	bindErr := tryBind(protocol, address, portNumber)
+	if bindErr == nil {
+		fmt.Println(target + " can be bound")
+		return 0
+	}
+
+	fmt.Printf("Can't bind %s: %s\n", target, bindErr)
+
+	switch {
+	case errors.Is(bindErr, syscall.EACCES):
+		fmt.Println("Ports below 1024 can only be bound by root, or processes with the CAP_NET_BIND_SERVICE capability.")
+		return 1
+
+	case errors.Is(bindErr, syscall.EADDRNOTAVAIL):
+		fmt.Println(address + " isn't an address on any of this host's interfaces.")
+		return 1
+
+	case !errors.Is(bindErr, syscall.EADDRINUSE):
+		return 1
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	processes, err := collectProcesses(settings{showIPv4: true, showIPv6: true})
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	conflicts := bindConflicts(processes, protocol, address, port)
+	if len(conflicts) == 0 {
+		fmt.Println("No process pvw can see is using the port. It may be owned by another user (try running pvw as root),")
+		fmt.Println("or held by connections in TIME_WAIT, which SO_REUSEADDR would let you bind over.")
+		return 1
+	}
+
+	listening := false
+	for _, proc := range conflicts {
+		for _, conn := range proc.connections {
+			state := strings.ToTitle(conn.status)
+			if state == "" {
+				state = "BOUND"
+			}
+			fmt.Printf("  %s (pid %d, user %s) has %s:%s %s\n", proc.name, proc.id, proc.username,
+				conn.localAddress, conn.localPort, state)
+
+			// UDP sockets don't listen, but binding to one blocks the port all the same
+			if conn.status == "LISTEN" || protocol == "udp" {
+				listening = true
+			}
+		}
+	}
+
+	if listening {
+		fmt.Println("SO_REUSEADDR won't help, as the port is already bound for listening. Port sharing (SO_REUSEPORT) only")
+		fmt.Println("works if every process using the port sets it, and they all run as the same user.")
+	} else {
+		fmt.Println("Nothing is listening on the port, only connections are using it, so binding with SO_REUSEADDR would work.")
+	}
+	return 1
+}
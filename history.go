@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Connection history
+// Remembers how many connections each process had over the last few refreshes, and draws them as a sparkline, so that
+// leaks and bursts stand out without having to export anything.
+
+// The characters used to draw sparklines, from no connections up to the most the process has had
+var sparklineBars = []rune(" ▁▂▃▄▅▆▇█")
+
+// The connection counts of a single process, as a ring buffer
+type processHistory struct {
+	counts []int // The counts, with the oldest at next once the buffer is full
+	next   int   // Where the next count goes
+	filled int   // How many counts have been recorded, up to the length of counts
+}
+
+// add() records a count, overwriting the oldest one if the buffer is full
+func (h *processHistory) add(count int) {
+	h.counts[h.next] = count
+	h.next = (h.next + 1) % len(h.counts)
+	if h.filled < len(h.counts) {
+		h.filled += 1
+	}
+}
+
+// ordered() gets the counts that have been recorded, oldest first
+func (h *processHistory) ordered() []int {
+	start := (h.next - h.filled + len(h.counts)) % len(h.counts)
+
+	ordered := make([]int, h.filled)
+	for i := range ordered {
+		ordered[i] = h.counts[(start+i)%len(h.counts)]
+	}
+	return ordered
+}
+
+// The history of every process, keyed by PID. Safe to use from multiple goroutines.
+type connectionHistory struct {
+	mu        sync.Mutex
+	processes map[int]*processHistory
+}
+
+// The history used by the TUI, which is added to on every refresh
+var history = &connectionHistory{processes: make(map[int]*processHistory)}
+
+// countConnections() counts a process' connections, or only the ones in a state if one is given
+func countConnections(proc process, state string) int {
+	if state == "" {
+		return len(proc.connections)
+	}
+
+	count := 0
+	for _, conn := range proc.connections {
+		if strings.EqualFold(conn.status, state) {
+			count += 1
+		}
+	}
+	return count
+}
+
+// record() adds the latest connection counts to the history. Processes that have been seen before but aren't in this
+// refresh get a count of 0, and are forgotten once they've had no connections for the whole history.
+func (c *connectionHistory) record(processes []process, options settings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[int]bool)
+	for _, proc := range processes {
+		h, exists := c.processes[proc.id]
+		if !exists {
+			h = &processHistory{counts: make([]int, options.historyLength)}
+			c.processes[proc.id] = h
+		}
+
+		h.add(countConnections(proc, options.historyState))
+		seen[proc.id] = true
+	}
+
+	for pid, h := range c.processes {
+		if seen[pid] {
+			continue
+		}
+
+		h.add(0)
+		if h.filled == len(h.counts) && maxCount(h.counts) == 0 {
+			delete(c.processes, pid)
+		}
+	}
+}
+
+// sparkline() draws the history of a process, scaled so that its highest count is a full bar
+func (c *connectionHistory) sparkline(pid int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, exists := c.processes[pid]
+	if !exists {
+		return ""
+	}
+
+	counts := h.ordered()
+	highest := maxCount(counts)
+	levels := len(sparklineBars) - 1
+
+	var line strings.Builder
+	for _, count := range counts {
+		bar := 0
+		if highest > 0 {
+			// Round up, so that any connections at all show as at least the smallest bar
+			bar = (count*levels + highest - 1) / highest
+		}
+		line.WriteRune(sparklineBars[bar])
+	}
+	return line.String()
+}
+
+// maxCount() gets the highest count in a slice of counts
+func maxCount(counts []int) int {
+	highest := 0
+	for _, count := range counts {
+		if count > highest {
+			highest = count
+		}
+	}
+	return highest
+}
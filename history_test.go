@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+// Processes hidden by the search and protocol filters still have their connections counted
+func TestHistoryIgnoresFilters(t *testing.T) {
+	saved := history
+	history = &connectionHistory{processes: make(map[int]*processHistory)}
+	defer func() { history = saved }()
+
+	collected := []process{
+		{id: 100, name: "nginx", connections: []connection{
+			{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: "80"},
+			{protocol: "TCP", status: "ESTABLISHED", localAddress: "10.0.0.1", localPort: "80", remoteAddress: "10.0.0.2",
+				remotePort: "50000"},
+		}},
+		{id: 200, name: "redis", connections: []connection{
+			{protocol: "TCP", status: "LISTEN", localAddress: "127.0.0.1", localPort: "6379"},
+		}},
+		{id: 300, name: "dnsmasq", connections: []connection{
+			{protocol: "UDP", status: "UNCONN", localAddress: "127.0.0.1", localPort: "53"},
+		}},
+	}
+	options := settings{showIPv4: true, showIPv6: true, historyLength: 3, searchTerm: "nginx", protocolFilter: []string{"tcp"}}
+
+	for i := 0; i < 3; i++ {
+		msg, isProcesses := renderProcesses(collected, nil, nil, options, true).(processesMsg)
+		if !isProcesses || len(msg.processes) != 1 {
+			t.Fatalf("the search should only leave nginx, got %+v", msg)
+		}
+	}
+
+	tests := []struct {
+		pid  int
+		want string
+	}{
+		{100, "███"},
+		{200, "███"},
+		{300, "███"},
+	}
+	for _, test := range tests {
+		if got := history.sparkline(test.pid); got != test.want {
+			t.Errorf("history of %d is %q, want %q", test.pid, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/table"
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Keymaps
+// The keys start off as one of the presets, picked with --keymap, and then any binding can be changed with --bind.
+// Bindings are named in flags by the action they do, like page-down or next-process.
+
+// The names of every action that can be bound, in the order they're listed in error messages
+var actionNames = []string{
+	"up", "down", "page-up", "page-down", "half-page-up", "half-page-down", "top", "bottom", "next-process",
+	"previous-process", "terminate", "refresh", "search", "escape", "back", "forward", "namespace", "protocol", "stats",
+	"peers", "select", "stop-unit", "restart-unit", "help", "quit",
+}
+
+// The presets, as the keys they change from the default keymap
+var keyPresets = map[string]map[string][]string{
+	"default": {},
+	"vim": {
+		"page-up":          {"ctrl+b", "pgup"},
+		"page-down":        {"ctrl+f", "pgdown"},
+		"half-page-up":     {"ctrl+u"},
+		"half-page-down":   {"ctrl+d"},
+		"top":              {"g", "home"},
+		"bottom":           {"G", "end"},
+		"next-process":     {"}"},
+		"previous-process": {"{"},
+	},
+	"emacs": {
+		"up":               {"ctrl+p", "up"},
+		"down":             {"ctrl+n", "down"},
+		"page-up":          {"alt+v", "pgup"},
+		"page-down":        {"ctrl+v", "pgdown"},
+		"top":              {"alt+<", "home"},
+		"bottom":           {"alt+>", "end"},
+		"next-process":     {"alt+}"},
+		"previous-process": {"alt+{"},
+		"search":           {"ctrl+s"},
+		"escape":           {"ctrl+g", "esc"},
+		"back":             {"ctrl+b", "left"},
+		"forward":          {"ctrl+f", "right"},
+	},
+}
+
+// How some keys are shown in the help
+var keySymbols = map[string]string{
+	"up":    "↑",
+	"down":  "↓",
+	"left":  "←",
+	"right": "→",
+	" ":     "space",
+}
+
+// binding() gets the binding for an action
+func (k *keyMap) binding(action string) *key.Binding {
+	bindings := map[string]*key.Binding{
+		"up":               &k.Up,
+		"down":             &k.Down,
+		"page-up":          &k.PageUp,
+		"page-down":        &k.PageDown,
+		"half-page-up":     &k.HalfPageUp,
+		"half-page-down":   &k.HalfPageDown,
+		"top":              &k.Top,
+		"bottom":           &k.Bottom,
+		"next-process":     &k.NextProcess,
+		"previous-process": &k.PreviousProcess,
+		"terminate":        &k.Terminate,
+		"refresh":          &k.Refresh,
+		"search":           &k.Search,
+		"escape":           &k.Escape,
+		"back":             &k.Back,
+		"forward":          &k.Forward,
+		"namespace":        &k.Namespace,
+		"protocol":         &k.Protocol,
+		"stats":            &k.Stats,
+		"peers":            &k.Peers,
+		"select":           &k.Select,
+		"stop-unit":        &k.StopUnit,
+		"restart-unit":     &k.RestartUnit,
+		"help":             &k.Help,
+		"quit":             &k.Quit,
+	}
+	return bindings[action]
+}
+
+// rebind() changes the keys for an action. The help keeps the same description, but shows the new keys.
+func (k *keyMap) rebind(action string, keys []string) {
+	binding := k.binding(action)
+
+	var shown []string
+	for _, name := range keys {
+		if symbol, exists := keySymbols[name]; exists {
+			name = symbol
+		}
+		shown = append(shown, name)
+	}
+
+	*binding = key.NewBinding(
+		key.WithKeys(keys...),
+		key.WithHelp(strings.Join(shown, "/"), binding.Help().Desc),
+	)
+}
+
+// newKeyMap() creates the keymap for a preset, with the bindings from --bind (like "top=home,g") changed on top of it
+func newKeyMap(preset string, binds []string) (keyMap, error) {
+	changes, exists := keyPresets[preset]
+	if !exists {
+		return keyMap{}, errors.New("Unknown keymap " + preset + ". Please use default, vim or emacs.")
+	}
+
+	bindings := keys
+	for _, action := range actionNames {
+		if presetKeys, changed := changes[action]; changed {
+			bindings.rebind(action, presetKeys)
+		}
+	}
+
+	for _, bind := range binds {
+		action, list, found := strings.Cut(bind, "=")
+		if !found || list == "" {
+			return keyMap{}, errors.New("Can't bind " + bind + ". Please use an action and its keys, like top=home,g.")
+		}
+		if !slices.Contains(actionNames, action) {
+			names := strings.Join(actionNames, ", ")
+			return keyMap{}, errors.New("Unknown action " + action + ". Please use one of " + names + ".")
+		}
+		bindings.rebind(action, strings.Split(list, ","))
+	}
+
+	if err := bindings.checkConflicts(); err != nil {
+		return keyMap{}, err
+	}
+	return bindings, nil
+}
+
+// checkConflicts() makes sure that no key is bound to more than one action, as only one of them would ever happen
+func (k *keyMap) checkConflicts() error {
+	owners := make(map[string]string)
+	for _, action := range actionNames {
+		for _, name := range k.binding(action).Keys() {
+			if owner, exists := owners[name]; exists && owner != action {
+				return errors.New("Key " + name + " is bound to both " + owner + " and " + action +
+					". Please use --bind to move one of them to another key.")
+			}
+			owners[name] = action
+		}
+	}
+	return nil
+}
+
+// tableKeys() gets the keys that move around a table, so that bubbles' table uses the same ones as everything else
+func (k keyMap) tableKeys() table.KeyMap {
+	return table.KeyMap{
+		LineUp:       k.Up,
+		LineDown:     k.Down,
+		PageUp:       k.PageUp,
+		PageDown:     k.PageDown,
+		HalfPageUp:   k.HalfPageUp,
+		HalfPageDown: k.HalfPageDown,
+		GotoTop:      k.Top,
+		GotoBottom:   k.Bottom,
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewKeyMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		preset  string
+		binds   []string
+		wantErr string
+	}{
+		{"default", "default", nil, ""},
+		{"vim", "vim", nil, ""},
+		{"emacs", "emacs", nil, ""},
+		{"rebinding", "default", []string{"top=home,t", "terminate=ctrl+t"}, ""},
+		{"shadowing quit", "default", []string{"top=q"}, "Key q is bound to both top and quit"},
+		{"shadowing a preset", "emacs", []string{"refresh=ctrl+s"}, "Key ctrl+s is bound to both refresh and search"},
+		{"unknown preset", "nano", nil, "Unknown keymap nano"},
+		{"unknown action", "default", []string{"jump=j"}, "Unknown action jump"},
+		{"missing keys", "default", []string{"top="}, "Can't bind top="},
+	}
+
+	for _, test := range tests {
+		_, err := newKeyMap(test.preset, test.binds)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Parsing lsof
+// lsof's -F output has one field per line, each starting with a character saying what it is. The output is read a line
+// at a time rather than split up all at once, so hosts with hundreds of thousands of sockets don't need a copy of every
+// field in memory. Filters are checked as soon as the fields they need have been read, so processes and connections
+// that get filtered out are never built.
+
+// The arguments lsof is run with. That's every internet socket, without resolving names or ports, in field output.
+var lsofArgs = []string{"-i", "-Pn", "-F", "cPnpLTt"}
+
+// The longest line lsof can give before the parser gives up. Lines are usually well under 100 bytes.
+const lsofMaxLine = 1024 * 1024
+
+// The most malformed lines that get kept track of. Any more are only counted, so garbage input can't use up memory.
+const lsofMaxErrors = 100
+
+// The ways a line of lsof's output can be malformed
+var (
+	errInvalidPID     = errors.New("invalid PID")
+	errInvalidType    = errors.New("not an internet socket")
+	errInvalidAddress = errors.New("invalid address")
+	errNoProcess      = errors.New("field before any process")
+)
+
+// A malformed line in lsof's output. The process or file the line belongs to gets skipped, rather than the whole output.
+type lsofError struct {
+	line  int    // The line number, starting from 1
+	field byte   // The field's identifier character
+	value string // The rest of the line
+	err   error  // What's wrong with it
+}
+
+func (e lsofError) Error() string {
+	return fmt.Sprintf("line %d: %v (%c%s)", e.line, e.err, e.field, e.value)
+}
+
+func (e lsofError) Unwrap() error { return e.err }
+
+// Every malformed line in lsof's output. It's returned along with everything that could be parsed.
+type malformedLsof struct {
+	errs    []lsofError // The first few malformed lines
+	skipped int         // How many lines were malformed
+}
+
+func (m malformedLsof) Error() string {
+	if m.skipped == 1 {
+		return "Skipped a malformed line in lsof's output, at " + m.errs[0].Error()
+	}
+	return fmt.Sprintf("Skipped %d malformed lines in lsof's output, starting at %v", m.skipped, m.errs[0])
+}
+
+// lsofParser keeps track of where it is in lsof's output, as the fields for a process and its files are spread over
+// many lines
+type lsofParser struct {
+	options      settings
+	ownNamespace string // The network namespace every connection from lsof is in
+	processes    []process
+
+	current     process // The process whose fields are being read
+	inProcess   bool    // Whether a process has been started
+	skipProcess bool    // Whether the current process has been filtered out, so its fields can be ignored
+
+	conn     connection // The connection whose fields are being read
+	inFile   bool       // Whether a file has been started
+	typed    bool       // Whether the current file has had its type (t) field
+	skipFile bool       // Whether the current file has been filtered out
+
+	// Users, protocols and states are repeated on almost every line, so they're only turned into strings once
+	interned map[string]string
+
+	line      int           // The number of the line being read
+	malformed malformedLsof // The lines that couldn't be parsed
+}
+
+// parseLsof() takes the raw string output of lsof and converts it to a slice of process structs based on the parsing
+// criteria given to it in a settings struct. Processes and files with malformed fields are skipped, and returned as a
+// malformedLsof error along with everything else.
+func parseLsof(raw string, options settings) ([]process, error) {
+	return parseLsofReader(strings.NewReader(raw), options)
+}
+
+// parseLsofReader() parses lsof's output as it's read, a line at a time
+func parseLsofReader(reader io.Reader, options settings) ([]process, error) {
+	parser := lsofParser{
+		options:   options,
+		processes: make([]process, 0),
+		interned:  make(map[string]string),
+	}
+
+	// lsof's connections are all in pvw's own network namespace
+	if options.namespaces {
+		parser.ownNamespace = getOwnNamespace()
+	}
+
+	// Containers can have started or stopped since the last refresh
+	if options.getContainers {
+		forgetDockerContainers()
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), lsofMaxLine)
+	for scanner.Scan() {
+		parser.line++
+		parser.field(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, lsofError{line: parser.line + 1, err: err}
+	}
+
+	parser.endProcess()
+
+	if options.groupByContainer {
+		groupByContainer(parser.processes)
+	}
+
+	if parser.malformed.skipped > 0 {
+		return parser.processes, parser.malformed
+	}
+	return parser.processes, nil
+}
+
+// fail() records a malformed line
+func (p *lsofParser) fail(field byte, value []byte, err error) {
+	if len(p.malformed.errs) < lsofMaxErrors {
+		p.malformed.errs = append(p.malformed.errs, lsofError{line: p.line, field: field, value: string(value), err: err})
+	}
+	p.malformed.skipped++
+}
+
+// intern() gets a string for some bytes, reusing the same string every time the same bytes come up
+func (p *lsofParser) intern(value []byte) string {
+	// Looking up a map with a converted []byte doesn't allocate
+	if text, exists := p.interned[string(value)]; exists {
+		return text
+	}
+
+	text := string(value)
+	p.interned[text] = text
+	return text
+}
+
+// field() handles a single line of lsof's output. Fields are told apart by their first character (see "OUTPUT FOR
+// OTHER PROGRAMS" in lsof's man page), so they can come in any order, and fields pvw doesn't use are ignored.
+func (p *lsofParser) field(line []byte) {
+	if len(line) == 0 {
+		return
+	}
+	id, value := line[0], line[1:]
+
+	// A PID starts a new process
+	if id == 'p' {
+		p.endProcess()
+
+		pid, err := strconv.Atoi(string(value))
+		p.current = process{id: pid, connections: make([]connection, 0)}
+		p.inProcess = true
+
+		// Skip everything up to the next process, as there's nothing to do with a process without a PID
+		if err != nil || pid <= 0 {
+			p.fail(id, value, errInvalidPID)
+			p.skipProcess = true
+			return
+		}
+
+		// lsof can't make sense of sockets in other network namespaces, so those processes get read from /proc instead
+		p.skipProcess = p.options.namespaces && !inOwnNamespace(pid)
+		return
+	}
+
+	if !p.inProcess {
+		p.fail(id, value, errNoProcess)
+		return
+	}
+	if p.skipProcess {
+		return
+	}
+
+	switch id {
+	case 'c':
+		// c: The process' name, which is all the name filter needs
+		name := string(value)
+		if !matchesNameFilter(name, p.options) {
+			p.skipProcess = true
+			return
+		}
+		p.current.name = name
+		break
+
+	case 'L':
+		// L: The name of the user running the process
+		p.current.username = p.intern(value)
+		break
+
+	case 'u':
+		// u: The ID of the user running the process, which is only used if lsof doesn't give their name
+		if p.current.username == "" {
+			p.current.username = p.intern(value)
+		}
+		break
+
+	case 'f':
+		// f: A file descriptor, which starts a new file
+		p.startFile()
+		break
+
+	case 't':
+		// t: The file's type, which is the IP version. Without a file descriptor field, this starts a new file instead.
+		if !p.inFile || p.typed {
+			p.startFile()
+		}
+		p.typed = true
+
+		if string(value) != "IPv4" && string(value) != "IPv6" {
+			p.fail(id, value, errInvalidType)
+			p.skipFile = true
+			return
+		}
+
+		p.conn.ipv6 = string(value) == "IPv6"
+		if !ipVersionAllowed(p.conn.ipv6, p.options) {
+			p.skipFile = true
+		}
+		break
+
+	case 'P':
+		// P: The protocol, like TCP or UDP
+		p.conn.protocol = p.intern(value)
+		if !protocolAllowed(p.conn.protocol, p.options) {
+			p.skipFile = true
+		}
+		break
+
+	case 'n':
+		// n: Local and remote addresses and ports
+		if !p.inFile || p.skipFile {
+			return
+		}
+		if !validAddresses(value) {
+			p.fail(id, value, errInvalidAddress)
+			p.skipFile = true
+			return
+		}
+		p.skipFile = !p.parseAddresses(value)
+		break
+
+	case 'T':
+		// T: TCP/TPI information. There's one line for each, and only the connection's state (ST=) is used.
+		if bytes.HasPrefix(value, []byte("ST=")) {
+			p.conn.status = strings.ToTitle(p.intern(value[3:]))
+		}
+		break
+	}
+}
+
+// parseAddresses() reads the local and remote ends of a connection from an n field. Returns false if the connection
+// should be skipped.
+func (p *lsofParser) parseAddresses(value []byte) bool {
+	// *:* usually indicates some unimportant connection, so we just make that connection invalid
+	// This might be wrong! If you want to submit an issue about this, then feel free!
+	if string(value) == "*:*" {
+		return false
+	}
+
+	// Check the port filter before copying anything out of the line
+	local, remote, hasRemote := bytes.Cut(value, []byte("->"))
+	if len(p.options.portFilter) > 0 {
+		_, localPort := splitAddressAndPort(local)
+		_, remotePort := splitAddressAndPort(remote)
+		if !containsPort(p.options.portFilter, localPort) && !(hasRemote && containsPort(p.options.portFilter, remotePort)) {
+			return false
+		}
+	}
+
+	// Every address and port shares the one copy of the line
+	addresses := string(value)
+	localEnd, remoteEnd, _ := strings.Cut(addresses, "->")
+
+	p.conn.localAddress, p.conn.localPort = splitAddressAndPortString(localEnd)
+	if hasRemote {
+		p.conn.remoteAddress, p.conn.remotePort = splitAddressAndPortString(remoteEnd)
+
+		// outbound connection, so use remote port for friendly name
+		p.conn.remoteName = serviceNames[p.conn.remotePort]
+	} else {
+		// friendly port name is local port as process is listening on this port
+		p.conn.localName = serviceNames[p.conn.localPort]
+	}
+	return true
+}
+
+// validAddresses() checks that an n field has an address and port at each end, like 127.0.0.1:80 or
+// [::1]:80->[::1]:5000. Host names with colons in them are fine, as the port is always after the last one.
+func validAddresses(value []byte) bool {
+	local, remote, hasRemote := bytes.Cut(value, []byte("->"))
+	if !validEnd(local) {
+		return false
+	}
+	return !hasRemote || validEnd(remote)
+}
+
+// validEnd() checks that one end of a connection has an address and a port
+func validEnd(end []byte) bool {
+	address, port := splitAddressAndPort(end)
+	return len(address) > 0 && len(port) > 0
+}
+
+// splitAddressAndPort() splits one end of a connection into its address and port. The port comes after the last colon,
+// so IPv6 addresses (which lsof puts in square brackets) keep all of theirs.
+func splitAddressAndPort(end []byte) ([]byte, []byte) {
+	colon := bytes.LastIndexByte(end, ':')
+	if colon < 0 {
+		return end, nil
+	}
+	return end[:colon], end[colon+1:]
+}
+
+// splitAddressAndPortString() is splitAddressAndPort() for strings
+func splitAddressAndPortString(end string) (string, string) {
+	colon := strings.LastIndexByte(end, ':')
+	if colon < 0 {
+		return end, ""
+	}
+	return end[:colon], end[colon+1:]
+}
+
+// containsPort() checks whether a port is in the port filter, without copying it
+func containsPort(ports []string, port []byte) bool {
+	for _, allowed := range ports {
+		if allowed == string(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// startFile() finishes the file being read, and starts reading the next one
+func (p *lsofParser) startFile() {
+	p.endFile()
+	p.conn = connection{namespace: p.ownNamespace}
+	p.inFile = true
+	p.typed = false
+	p.skipFile = false
+}
+
+// endFile() adds the file that's been read to the current process, if it passes the filters
+func (p *lsofParser) endFile() {
+	if p.inFile && p.conn.status == "" && strings.EqualFold(p.conn.protocol, "UDP") {
+		p.conn.status = udpState(p.conn)
+	}
+
+	// That connection has been parsed! Check it against the port and status filters, then add it to the slice. Files
+	// without an n field don't have any addresses, so there's nothing to show for them.
+	if p.inFile && !p.skipFile && p.conn.localPort != "" && connectionAllowed(p.conn, p.options) {
+		p.current.connections = append(p.current.connections, p.conn)
+	}
+	p.inFile = false
+}
+
+// endProcess() adds the process that's been read to the list, if it still has a connection and passes the filters.
+// The details that don't come from lsof are only looked up for processes that are kept.
+func (p *lsofParser) endProcess() {
+	if !p.inProcess {
+		return
+	}
+	p.endFile()
+	p.inProcess = false
+
+	if p.skipProcess || len(p.current.connections) == 0 {
+		return
+	}
+
+	// We have the pid, so we can use that to get the CWD. This fails when the process has exited since lsof saw it, or
+	// when pvw isn't allowed to look at it, so the directory is left empty rather than treating lsof's output as wrong.
+	if p.options.getCwd {
+		if cwd, err := getCwd(p.current.id); err == nil {
+			p.current.directory = cwd
+		}
+	}
+
+	lookupProcessDetails(&p.current, p.options)
+
+	// If the process is in the right container and unit, then add it to the slice
+	if processAllowed(p.current, p.options) {
+		p.processes = append(p.processes, p.current)
+	}
+}
+
+// streamLsof() runs lsof and parses its output as it comes in, without keeping a copy of all of it. lsof exits with
+// code 1 when it doesn't find anything, but also when it couldn't read some processes (like ones owned by other users),
+// so whatever it did find is still used.
+func streamLsof(options settings) ([]process, error) {
+	cmd := exec.Command("lsof", lsofArgs...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	processes, err := parseLsofReader(stdout, options)
+	var malformed malformedLsof
+	if err != nil && !errors.As(err, &malformed) {
+		// Stop lsof rather than waiting for it to write the rest
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	if waitErr := cmd.Wait(); waitErr != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(waitErr, &exitErr) || exitErr.ExitCode() != 1 {
+			return nil, waitErr
+		}
+	}
+
+	if processes == nil {
+		processes = []process{}
+	}
+	return processes, err
+}
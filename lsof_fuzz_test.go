@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The settings each input is parsed with: everything, and a filter that only keeps some of it
+var fuzzLsofSettings = []settings{
+	{showIPv4: true, showIPv6: true},
+	{showIPv4: true, protocolFilter: []string{"tcp"}, portFilter: []string{"80"}},
+}
+
+func FuzzParseLsof(f *testing.F) {
+	// Make sure every set of settings keeps something, or it wouldn't be testing anything
+	for _, options := range fuzzLsofSettings {
+		if processes, _ := parseLsof("p1\ncnginx\ntIPv4\nPTCP\nn*:80\nTST=LISTEN\n", options); len(processes) == 0 {
+			f.Fatalf("parsing with %+v doesn't keep anything", options)
+		}
+	}
+
+	corpus, err := filepath.Glob(filepath.Join("testdata", "lsof", "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range corpus {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+
+	// Odd lines that are easy to get wrong: empty fields, missing addresses, and fields without a process
+	f.Add("p\n")
+	f.Add("p1\nn\n")
+	f.Add("p1\ntIPv4\nPTCP\nn:\n")
+	f.Add("p1\ntIPv4\nPTCP\nn[::1]\n")
+	f.Add("p1\ntIPv4\nPTCP\nn->\nT\nTST=\n")
+	f.Add("f3\ntIPv4\nPTCP\nn*:80\n")
+	f.Add("p1\r\nccrlf\r\ntIPv4\r\nPTCP\r\nn*:80\r\n")
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		for _, options := range fuzzLsofSettings {
+			processes, err := parseLsof(raw, options)
+
+			var malformed malformedLsof
+			if err != nil && !errors.As(err, &malformed) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil && (malformed.skipped == 0 || len(malformed.errs) == 0 || len(malformed.errs) > lsofMaxErrors) {
+				t.Fatalf("skipped %d lines with %d errors", malformed.skipped, len(malformed.errs))
+			}
+
+			for _, proc := range processes {
+				if proc.id <= 0 {
+					t.Fatalf("kept a process with PID %d", proc.id)
+				}
+				if len(proc.connections) == 0 {
+					t.Fatalf("kept process %d without any connections", proc.id)
+				}
+				for _, conn := range proc.connections {
+					if conn.localPort == "" {
+						t.Fatalf("kept a connection of process %d without a local port", proc.id)
+					}
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describeProcesses() describes each connection in a line, along with its process, so parsed output is easy to compare
+func describeProcesses(processes []process) []string {
+	var lines []string
+	for _, proc := range processes {
+		user := proc.username
+		if user == "" {
+			user = "-"
+		}
+
+		for _, conn := range proc.connections {
+			ends := conn.localAddress + ":" + conn.localPort
+			if conn.remotePort != "" {
+				ends += "->" + conn.remoteAddress + ":" + conn.remotePort
+			}
+			lines = append(lines, fmt.Sprintf("%d %s %s %s %s %s", proc.id, proc.name, user, conn.protocol, ends, conn.status))
+		}
+	}
+	return lines
+}
+
+// A malformed line that the parser should report
+type wantLsofError struct {
+	line int
+	err  error
+}
+
+// checkLsofErrors() checks that parsing returned the malformed lines that were expected, and nothing else
+func checkLsofErrors(t *testing.T, name string, err error, want []wantLsofError) {
+	t.Helper()
+
+	if len(want) == 0 {
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		return
+	}
+
+	var malformed malformedLsof
+	if !errors.As(err, &malformed) {
+		t.Errorf("%s: error = %v, want malformed lines", name, err)
+		return
+	}
+	if malformed.skipped != len(want) || len(malformed.errs) != len(want) {
+		t.Errorf("%s: %d malformed lines, want %d: %v", name, malformed.skipped, len(want), malformed.errs)
+		return
+	}
+
+	for i, got := range malformed.errs {
+		if got.line != want[i].line || !errors.Is(got, want[i].err) {
+			t.Errorf("%s: malformed line %d is %v, want line %d: %v", name, i, got, want[i].line, want[i].err)
+		}
+	}
+}
+
+func TestParseLsofFixtures(t *testing.T) {
+	tests := []struct {
+		file   string
+		want   []string
+		errors []wantLsofError
+	}{
+		{"listening.txt", []string{
+			"100 nginx www-data TCP *:80 LISTEN",
+			"100 nginx www-data TCP [::1]:8080->[::1]:51000 ESTABLISHED",
+			"200 systemd-resolve systemd-resolve UDP 127.0.0.53:53 UNCONN",
+		}, nil},
+		// Without an L field, the user ID is used if there is one
+		{"missing-user.txt", []string{
+			"300 redis-server - TCP 127.0.0.1:6379 LISTEN",
+			"301 redis-sentinel 999 TCP 127.0.0.1:26379 LISTEN",
+		}, nil},
+		// The port is always after the last colon
+		{"colon-hostnames.txt", []string{
+			"400 postgres postgres TCP [fe80::1%eth0]:5432 LISTEN",
+			"400 postgres postgres TCP web:frontend:8443->db:primary:5432 ESTABLISHED",
+		}, nil},
+		// Only ST= is used out of the T fields, and the others (even empty ones) are ignored
+		{"tcp-info.txt", []string{
+			"500 haproxy haproxy TCP 10.0.0.1:443 LISTEN",
+			"500 haproxy haproxy TCP 10.0.0.1:443->10.0.0.9:40000 ESTABLISHED",
+		}, nil},
+		{"out-of-order.txt", []string{
+			"600 postgres postgres TCP 127.0.0.1:5432 LISTEN",
+			"600 postgres postgres TCP 127.0.0.1:5432->127.0.0.1:60000 ESTABLISHED",
+		}, nil},
+		{"malformed.txt", []string{
+			"700 good root TCP 10.0.0.1:22 LISTEN",
+			"701 weird root TCP 10.0.0.3:8080 LISTEN",
+		}, []wantLsofError{{1, errNoProcess}, {10, errInvalidPID}, {20, errInvalidType}, {25, errInvalidAddress}}},
+	}
+
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", "lsof", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		processes, err := parseLsof(string(data), settings{showIPv4: true, showIPv6: true})
+		checkLsofErrors(t, test.file, err, test.errors)
+
+		if got := describeProcesses(processes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parsed\n%s\nwant\n%s", test.file, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestParseLsofErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		options settings
+		want    []string
+		errors  []wantLsofError
+	}{
+		{
+			name: "empty",
+			raw:  "",
+		},
+		{
+			name:   "PID of zero",
+			raw:    "p0\ncinit\ntIPv4\nPTCP\nn*:1\n",
+			errors: []wantLsofError{{1, errInvalidPID}},
+		},
+		{
+			name:   "missing port",
+			raw:    "p1\ncfoo\ntIPv4\nPTCP\nn10.0.0.1:80->10.0.0.2\np2\ncbar\ntIPv4\nPTCP\nn*:81\n",
+			want:   []string{"2 bar - TCP *:81 "},
+			errors: []wantLsofError{{5, errInvalidAddress}},
+		},
+		{
+			name:   "unix socket",
+			raw:    "p1\ncfoo\nf3\ntunix\nn/run/foo.sock\nf4\ntIPv6\nPUDP\nn[::]:53\n",
+			want:   []string{"1 foo - UDP [::]:53 UNCONN"},
+			errors: []wantLsofError{{4, errInvalidType}},
+		},
+		{
+			// The process has gone by the time its working directory is looked up, which isn't lsof's fault
+			name:    "process exited",
+			raw:     "p2147483000\ncgone\nLroot\nf3\ntIPv4\nPTCP\nn10.0.0.1:22\nTST=LISTEN\n",
+			options: settings{getCwd: true},
+			want:    []string{"2147483000 gone root TCP 10.0.0.1:22 LISTEN"},
+		},
+		{
+			// A file without an n field has nothing to show, so it's dropped along with its process
+			name: "no addresses",
+			raw:  "p1\ncfoo\nf3\ntIPv4\nPTCP\nTST=LISTEN\n",
+		},
+		{
+			// Filtered out processes are skipped without being checked
+			name:    "filtered out",
+			raw:     "p1\ncfoo\ntIPv4\nPTCP\nnnoport\n",
+			options: settings{nameFilter: []string{"bar"}},
+		},
+	}
+
+	for _, test := range tests {
+		options := test.options
+		options.showIPv4, options.showIPv6 = true, true
+
+		processes, err := parseLsof(test.raw, options)
+		checkLsofErrors(t, test.name, err, test.errors)
+
+		if got := describeProcesses(processes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parsed %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseLsofTooManyErrors(t *testing.T) {
+	raw := strings.Repeat("cstray\n", lsofMaxErrors+50)
+
+	_, err := parseLsof(raw, settings{showIPv4: true, showIPv6: true})
+
+	var malformed malformedLsof
+	if !errors.As(err, &malformed) {
+		t.Fatalf("error = %v, want malformed lines", err)
+	}
+	if malformed.skipped != lsofMaxErrors+50 || len(malformed.errs) != lsofMaxErrors {
+		t.Errorf("skipped %d lines and kept %d errors, want %d and %d", malformed.skipped, len(malformed.errs),
+			lsofMaxErrors+50, lsofMaxErrors)
+	}
+	if last := malformed.errs[len(malformed.errs)-1]; last.line != lsofMaxErrors {
+		t.Errorf("last error kept is on line %d, want %d", last.line, lsofMaxErrors)
+	}
+}
+
+// lsof exits with 1 when it couldn't read some processes, and what it did read should still be used
+func TestStreamLsofPartial(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{"nothing found", "", nil},
+		{"some processes unreadable", "p100\ncnginx\nLwww-data\nf6\ntIPv4\nPTCP\nn*:80\nTST=LISTEN\n",
+			[]string{"100 nginx www-data TCP *:80 LISTEN"}},
+	}
+
+	for _, test := range tests {
+		// A fake lsof that prints the output, then fails
+		bin := t.TempDir()
+		script := "#!/bin/sh\nprintf '" + strings.ReplaceAll(test.output, "\n", "\\n") + "'\nexit 1\n"
+		if err := os.WriteFile(filepath.Join(bin, "lsof"), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		processes, err := streamLsof(settings{showIPv4: true, showIPv6: true})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if processes == nil {
+			t.Errorf("%s: got no slice of processes", test.name)
+		}
+		if got := describeProcesses(processes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parsed %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// syntheticLsof() generates lsof output for a busy host, like a load balancer, with 50 connections for each process
+func syntheticLsof(sockets int) string {
+	var b strings.Builder
+	for i := 0; i < sockets; i++ {
+		if i%50 == 0 {
+			fmt.Fprintf(&b, "p%d\ncnginx\nLwww-data\n", 1000+i/50)
+		}
+		fmt.Fprintf(&b, "f%d\ntIPv4\nPTCP\nn10.0.%d.%d:443->192.168.%d.%d:%d\nTST=ESTABLISHED\nTQR=0\nTQS=0\n",
+			3+i%50, i/256%256, i%256, i/200%256, i%200, 1024+i%60000)
+	}
+	return b.String()
+}
+
+func BenchmarkParseLsof(b *testing.B) {
+	raw := syntheticLsof(200000)
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := parseLsof(raw, settings{showIPv4: true, showIPv6: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Filtering by port should skip the connections without copying anything out of them
+func BenchmarkParseLsofFiltered(b *testing.B) {
+	raw := syntheticLsof(200000)
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := parseLsof(raw, settings{showIPv4: true, showIPv6: true, portFilter: []string{"2000"}}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"golang.org/x/exp/slices"
 	"runtime"
@@ -39,6 +40,11 @@ type process struct {
 	directory   string
 	connections []connection
 	username    string
+
+	containerID   string // The ID of the container the process is running in, if it's in one
+	containerName string // The name of that container, if the container runtime could be asked for it
+
+	unit string // The systemd unit the process belongs to, if it's in one
 }
 
 // A connection. Contains a protocol type (typically tcp or udp), connection status, remote address and port,
@@ -57,14 +63,25 @@ type connection struct {
 	localAddress string
 
 	ipv6 bool
+
+	namespace string // The network namespace the connection is in. Only set when looking in every namespace
+
+	rxRate    float64 // The bytes per second received since the last refresh. Only set when tracking throughput
+	txRate    float64 // The bytes per second sent since the last refresh
+	rateKnown bool    // Whether the kernel had byte counters for the connection (only TCP connections have them)
 }
 
 // The settings struct. Contains all the settings for parsing and rendering the table
 type settings struct {
-	readOnly   bool // Allow process termination
-	showClosed bool // Allow closed ports to be displayed
-	listenOnly bool // Filter to ports that are listening
-	getCwd     bool // Enable getting the CWD of a process
+	readOnly    bool // Allow process termination
+	showClosed  bool // Allow closed ports to be displayed
+	listenOnly  bool // Filter to ports that are listening
+	exposedOnly bool // Filter to ports that are listening where other hosts can reach them
+	getCwd      bool // Enable getting the CWD of a process
+
+	getContainers    bool // Enable getting the container of a process
+	groupByContainer bool // Sort processes so the ones in the same container are together
+	getUnits         bool // Enable getting the systemd unit of a process
 
 	showIPv6 bool // Enable IPv6
 	showIPv4 bool // Enable IPv4
@@ -75,8 +92,33 @@ type settings struct {
 	portFilter []string // The port numbers to filter by - don't filter if empty
 	nameFilter []string // The port names to filter by - don't filter if empty
 
+	protocolFilter []string // The protocols to filter by, in lowercase - don't filter if empty
+
+	containerFilter []string // The container names or IDs to filter by - don't filter if empty
+	unitFilter      []string // The systemd units to filter by - don't filter if empty
+
+	namespaces      bool     // Whether to look for connections in every network namespace, not just pvw's own
+	namespaceFilter []string // The network namespaces to filter by - don't filter if empty
+
+	peersByPort bool // Whether to group remote peers by port as well as address
+
+	throughput     bool   // Enable working out how fast each connection is moving data
+	sortBy         string // The column to sort processes and connections by - don't sort if empty
+	sortDescending bool   // Whether to sort highest first
+
+	historyLength int    // The number of refreshes to keep connection counts for - don't keep any if 0
+	historyState  string // Only count connections in this state in the history - count all of them if empty
+
 	searchTerm    string // The search term - gets added onto the nameFilter if not an empty string
 	displaySearch bool   // Whether to display the search bar or not
+
+	mouse bool // Whether to handle clicks and the scroll wheel in the TUI
+
+	themeName string // The theme from --theme, which is only loaded when the TUI starts (see useTheme())
+	noColor   bool   // Whether to turn colours off, whatever the theme
+	theme     theme  // The colours the TUI is drawn with
+
+	keys keyMap // The keys the TUI uses, from the keymap preset and any changed bindings
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -89,7 +131,30 @@ type model struct {
 	rowStarts []int       // The end of each process's list of open ports
 	processes []process   // A slice of process structs
 	err       error       // The most recent error
-	lsofOut   string      // The most recent lsof output as plaintext
+	warning   error       // Parts of the most recent lsof output that couldn't be parsed, if any
+
+	systemctl systemctlRunner // Runs systemctl for the unit actions
+	pending   *unitRequest    // The unit action waiting to be confirmed, if any
+	collected []process       // The processes from the last refresh, before filtering. Used when re-rendering
+
+	namespaced []process // The processes in other network namespaces, before filtering. Used when re-rendering
+	namespaces []string  // Every network namespace with a connection in it, for switching between them
+
+	replay []snapshot // The snapshots loaded from a recording. Empty unless running `pvw replay`
+	frame  int        // The index of the snapshot currently being shown from replay
+
+	screen screen // The screen being shown instead of the table, if any
+
+	peerTable           table.Model // The table of remote peers on the peers screen
+	peerConnectionTable table.Model // The table of connections to the peer being looked at
+	peers               []peerGroup // The remote peers of the processes in the table
+	openPeer            string      // The label of the peer whose connections are being looked at, if any
+
+	// How far each table has been scrolled, for working out which row was clicked
+	scroll               tableScroll
+	peerScroll           tableScroll
+	peerConnectionScroll tableScroll
+	windowHeight         int // The terminal's height, which decides how much of the view fits on screen
 
 	// Settings are stored in the settings struct. Includes render and parsing settings
 	settings settings
@@ -104,6 +169,31 @@ type model struct {
 
 }
 
+// A systemctl action on the unit of a process, which is asked about before it's run
+type unitRequest struct {
+	action string
+	proc   process
+}
+
+// question() asks whether to run the action, in the same way `pvw who --kill` asks before terminating
+func (r unitRequest) question() string {
+	unit := r.proc.unit
+	if unit == "" {
+		unit = "the systemd unit of " + r.proc.name
+	}
+	action := strings.ToUpper(r.action[:1]) + r.action[1:]
+	return strings.TrimSpace(confirmPrompt(fmt.Sprintf("%s %s (pid %d)?", action, unit, r.proc.id)))
+}
+
+// The screens that can be shown in the TUI
+type screen int
+
+const (
+	processesScreen screen = iota // The table of processes
+	statsScreen                   // Statistics about the processes in the table
+	peersScreen                   // The remote peers of the connections in the table
+)
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // MESSAGES
@@ -113,10 +203,12 @@ type model struct {
 func (e errMsg) Error() string { return e.err.Error() }
 
 type processesMsg struct { // A struct comprised of process structs and table rows
-	processes []process
-	rows      []table.Row
-	ends      []int
-	raw       string
+	processes  []process
+	rows       []table.Row
+	ends       []int
+	collected  []process // Every process from the last refresh, before the filters that can change without one
+	namespaced []process
+	warning    error // Parts of the lsof output that couldn't be parsed, if any
 }
 type errMsg struct{ err error } // An error message.
 type terminateMsg struct{}      // The message returned when terminating a process doesn't error. This then results
@@ -131,12 +223,34 @@ type keyMap struct {
 	Up   key.Binding
 	Down key.Binding
 
+	PageUp       key.Binding
+	PageDown     key.Binding
+	HalfPageUp   key.Binding
+	HalfPageDown key.Binding
+	Top          key.Binding
+	Bottom       key.Binding
+
+	NextProcess     key.Binding
+	PreviousProcess key.Binding
+
 	Terminate key.Binding
 	Refresh   key.Binding
 
 	Search key.Binding
 	Escape key.Binding
 
+	Back    key.Binding
+	Forward key.Binding
+
+	Namespace key.Binding
+	Protocol  key.Binding
+	Stats     key.Binding
+	Peers     key.Binding
+	Select    key.Binding
+
+	StopUnit    key.Binding
+	RestartUnit key.Binding
+
 	Help key.Binding
 	Quit key.Binding
 }
@@ -150,6 +264,38 @@ var keys = keyMap{
 		key.WithKeys("down", "j"),
 		key.WithHelp("↓/j", "move down"),
 	),
+	PageUp: key.NewBinding(
+		key.WithKeys("pgup", "b"),
+		key.WithHelp("pgup/b", "page up"),
+	),
+	PageDown: key.NewBinding(
+		key.WithKeys("pgdown", "f", " "),
+		key.WithHelp("pgdn/f", "page down"),
+	),
+	HalfPageUp: key.NewBinding(
+		key.WithKeys("u", "ctrl+u"),
+		key.WithHelp("u", "half a page up"),
+	),
+	HalfPageDown: key.NewBinding(
+		key.WithKeys("d", "ctrl+d"),
+		key.WithHelp("d", "half a page down"),
+	),
+	Top: key.NewBinding(
+		key.WithKeys("home", "g"),
+		key.WithHelp("home/g", "go to the top"),
+	),
+	Bottom: key.NewBinding(
+		key.WithKeys("end", "G"),
+		key.WithHelp("end/G", "go to the bottom"),
+	),
+	NextProcess: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "next process"),
+	),
+	PreviousProcess: key.NewBinding(
+		key.WithKeys("N"),
+		key.WithHelp("N", "previous process"),
+	),
 	Terminate: key.NewBinding(
 		key.WithKeys("t"),
 		key.WithHelp("t", "terminate selected process"),
@@ -166,6 +312,42 @@ var keys = keyMap{
 		key.WithKeys("/"),
 		key.WithHelp("/", "toggle the search bar"),
 	),
+	Back: key.NewBinding(
+		key.WithKeys("left", "h"),
+		key.WithHelp("←/h", "previous snapshot"),
+	),
+	Forward: key.NewBinding(
+		key.WithKeys("right", "l"),
+		key.WithHelp("→/l", "next snapshot"),
+	),
+	StopUnit: key.NewBinding(
+		key.WithKeys("S"),
+		key.WithHelp("S", "stop selected process' systemd unit"),
+	),
+	RestartUnit: key.NewBinding(
+		key.WithKeys("R"),
+		key.WithHelp("R", "restart selected process' systemd unit"),
+	),
+	Namespace: key.NewBinding(
+		key.WithKeys("tab"),
+		key.WithHelp("tab", "switch network namespace"),
+	),
+	Protocol: key.NewBinding(
+		key.WithKeys("P"),
+		key.WithHelp("P", "switch protocol"),
+	),
+	Stats: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "toggle statistics"),
+	),
+	Peers: key.NewBinding(
+		key.WithKeys("p"),
+		key.WithHelp("p", "toggle remote peers"),
+	),
+	Select: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter/esc", "open/close a peer's connections"),
+	),
 	Help: key.NewBinding(
 		key.WithKeys("?"),
 		key.WithHelp("?", "toggle help"),
@@ -190,22 +372,17 @@ func (k keyMap) ShortHelp() []key.Binding {
 // key.Map interface.
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.Up, k.Down},
-		{k.Refresh, k.Help},
-		{k.Terminate, k.Search},
-		{k.Quit},
+		{k.Up, k.Down, k.PageUp, k.PageDown},
+		{k.HalfPageUp, k.HalfPageDown, k.Top, k.Bottom},
+		{k.NextProcess, k.PreviousProcess, k.Refresh, k.Help},
+		{k.Terminate, k.Search, k.StopUnit, k.RestartUnit},
+		{k.Back, k.Forward, k.Namespace, k.Protocol},
+		{k.Stats, k.Peers, k.Select, k.Quit},
 	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
 
-// Lipgloss' base style.
-var baseStyle = lipgloss.NewStyle().
-	BorderStyle(lipgloss.NormalBorder()).
-	BorderForeground(lipgloss.Color("240"))
-
-// ---------------------------------------------------------------------------------------------------------------------
-
 // Variable containing hashmap for port numbers to service names:
 var serviceNames = map[string]string{
 	"20":    "ftp-data",
@@ -290,68 +467,116 @@ var serviceNames = map[string]string{
 func checkProcesses(settingsInfo settings) tea.Cmd {
 	return func() tea.Msg {
 
-		out, err := getLsof()
+		collected, err := streamLsof(refreshSettings(settingsInfo))
 
-		if err != nil {
-			if !(err.Error() == "1") {
-
-				return processesMsg{nil, nil, nil, out} // No processes, so return empty process slice
-			}
-			// Error if we fail, rather than running extra code
+		// Malformed lines only lose the processes they're in, so show everything else along with a warning
+		var malformed malformedLsof
+		var warning error
+		if errors.As(err, &malformed) {
+			warning = malformed
+		} else if err != nil {
 			return errMsg{err}
 		}
 
-		// We have a string that represents the `lsof` output. Parse that
-		// into a slice of process structs with the parseLsof() function
-		parsed, err := parseLsof(out, settingsInfo)
-
-		if err != nil {
-			return errMsg{err}
+		// lsof only sees its own network namespace, so read the sockets in the others separately
+		var namespaced []process
+		if settingsInfo.namespaces {
+			namespaced = collectNamespaces(settingsInfo)
 		}
 
-		//fmt.Println(len(parsed))
-		//d1 := string(len(parsed))
-		//_ = os.WriteFile("/tmp/log2", []byte(d1), 0644)
-
-		formatted, ends, err := formatLsof(parsed, settingsInfo)
+		// Throughput is worked out between refreshes, so it's sampled on every refresh rather than when rendering
+		if settingsInfo.throughput {
+			if sampleErr := throughput.sample(); sampleErr != nil {
+				return errMsg{sampleErr}
+			}
+			throughput.addRates(collected)
+			throughput.addRates(namespaced)
+		}
 
-		return processesMsg{parsed, formatted, ends, out}
+		return renderProcesses(collected, namespaced, warning, settingsInfo, true)
 
 	}
 }
 
-func rerenderProcesses(mostRecent string, settingsInfo settings) tea.Cmd {
-	return func() tea.Msg {
-		// We have a string that represents the `lsof` output. Parse that
-		// into a slice of process structs with the parseLsof() function
-		parsed, err := parseLsof(mostRecent, settingsInfo)
+// refreshSettings() gets the settings to parse lsof's output with in the TUI. The filters that can be changed without
+// refreshing (the search bar, and switching protocol or namespace) are left out, so the processes can be filtered
+// again when they change without running lsof again. Everything else is still filtered out while parsing.
+func refreshSettings(settingsInfo settings) settings {
+	settingsInfo.searchTerm = ""
+	settingsInfo.protocolFilter = nil
+	settingsInfo.namespaceFilter = nil
+	return settingsInfo
+}
 
-		if err != nil {
-			return errMsg{err}
-		}
+func rerenderProcesses(collected, namespaced []process, warning error, settingsInfo settings) tea.Cmd {
+	return func() tea.Msg {
+		return renderProcesses(collected, namespaced, warning, settingsInfo, false)
+	}
 
-		formatted, ends, err := formatLsof(parsed, settingsInfo)
+}
 
-		return processesMsg{parsed, formatted, ends, mostRecent}
+// renderProcesses() filters the processes from the last refresh and converts them to table rows, along with the
+// processes from any other network namespaces. It returns the message that gets sent to bubbletea. refreshed is true
+// when the processes are new, rather than being rendered again after a change to the filters, and is when the
+// connection history gets added to.
+func renderProcesses(collected, namespaced []process, warning error, settingsInfo settings, refreshed bool) tea.Msg {
+	all := make([]process, 0, len(collected)+len(namespaced))
+	all = append(all, collected...)
+	all = append(all, namespaced...)
+
+	// Filtering sorts the processes too, and groups them by container afterwards, which keeps the sorted order inside
+	// each container
+	parsed := filterProcesses(all, settingsInfo)
+
+	// The history is kept for every process, not just the ones the search and protocol filters leave, so that it's still
+	// right when they're shown again
+	if refreshed && settingsInfo.historyLength > 0 {
+		history.record(filterProcesses(all, refreshSettings(settingsInfo)), settingsInfo)
+	}
 
+	formatted, ends, err := formatLsof(parsed, settingsInfo)
+	if err != nil {
+		return errMsg{err}
 	}
 
+	return processesMsg{parsed, formatted, ends, collected, namespaced, warning}
 }
 
-// getLsof() runs the desired command and returns the output as a raw string or an error
-func getLsof() (string, error) {
-	// Set the command to use and get the output of that command (as well as any error codes we may encounter)
-	// Command is `lsof -i -Pn -F cPnpLTt`
-	cmd := exec.Command("lsof", "-i", "-Pn", "-F", "cPnpLTt")
-	out, err := cmd.Output()
+// collectProcesses() runs lsof once and parses its output with the given settings, along with the sockets in every
+// other network namespace if they're being looked at. It's used by the subcommands that don't run the TUI. lsof exits
+// with code 1 when it doesn't find anything, so that's treated as no processes.
+func collectProcesses(settingsInfo settings) ([]process, error) {
+	processes, err := streamLsof(settingsInfo)
+
+	// Malformed lines only lose the processes they're in, so carry on without them
+	var malformed malformedLsof
+	if errors.As(err, &malformed) {
+		fmt.Fprintln(os.Stderr, "Warning: "+malformed.Error())
+	} else if err != nil {
+		return nil, err
+	}
 
-	if err != nil {
-		// There's an error, return an empty string & the error. We'll parse error code 1 (no processes found) later on.
-		return "", err
+	// lsof only sees its own network namespace, so add the sockets in the others, the same way the TUI does
+	var namespaced []process
+	if settingsInfo.namespaces {
+		namespaced = filterProcesses(collectNamespaces(settingsInfo), settingsInfo)
+		processes = append(processes, namespaced...)
+	}
+
+	if settingsInfo.throughput {
+		if err := throughput.sample(); err != nil {
+			return nil, err
+		}
+		throughput.addRates(processes)
 	}
 
-	// We have valid data, so return it! The out variable is a byte array, so convert it to a string first.
-	return string(out), err
+	if settingsInfo.sortBy != "" {
+		sortProcesses(processes, settingsInfo.sortBy, settingsInfo.sortDescending)
+	}
+	if settingsInfo.groupByContainer && (len(namespaced) > 0 || settingsInfo.sortBy != "") {
+		groupByContainer(processes)
+	}
+	return processes, nil
 }
 
 // getCwd() gets the working directory of a process from a PID
@@ -376,251 +601,137 @@ func getCwd(pid int) (string, error) {
 
 }
 
-// parseLsof() takes the raw string output of lsof and converts it to a slice of process structs based on the parsing
-// criteria given to it in a settings struct
-func parseLsof(raw string, options settings) ([]process, error) {
-
-	// Input will be a string. Processes are separated by \np (newline, then 'p' character)
-	separated := strings.Split(raw, "\np")
-
-	// Create a new slice of processes
-	allProcesses := make([]process, 0)
-
-	// For each process
-	for processIndex, processString := range separated {
-		// Start by splitting process info by \np (newline, then 'p' character) to get each port open as a separate item
-		// in a slice, as well as the PID, command, and user in the 1st item in the array
+// matchesNameFilter() checks whether a process name passes both the name filter and the search term
+func matchesNameFilter(name string, options settings) bool {
+	// If neither search nor nameFilter are enabled
+	return (!(len(options.nameFilter) > 0) && (options.searchTerm == "")) ||
+		// If we have a valid name filter but no search term
+		((options.searchTerm == "") && slices.Contains(options.nameFilter, name)) ||
+		// if we have a valid search term and no filter
+		(!(len(options.nameFilter) > 0) && strings.Contains(name, options.searchTerm)) ||
+		// If we have a filter and search term and both are matched
+		(((len(options.nameFilter) > 0) && (options.searchTerm != "")) && strings.Contains(name, options.searchTerm) && slices.Contains(options.nameFilter, name))
+}
 
-		connectionSplit := strings.Split(processString, "\nt")
+// processAllowed() checks a process against the container and systemd unit filters
+func processAllowed(proc process, options settings) bool {
+	return matchesContainerFilter(proc, options) && matchesUnitFilter(proc, options)
+}
 
-		// there will always be a 1st (index 0) element, which should contain the following lines:
-		// 88917 (process ID - no initial character as we split using that character earlier EXCEPT in process
-		// with index 0, as no newline before it)
-		// cProcessName
-		// LUserName
+// lookupProcessDetails() fills in the details of a process that lsof doesn't give. They're only looked up when they're
+// going to be used, as it means reading from /proc for every process.
+func lookupProcessDetails(proc *process, options settings) {
+	if options.getContainers {
+		proc.containerID, proc.containerName = getContainer(proc.id, proc.name)
+	}
+	if options.getUnits {
+		proc.unit = getSystemdUnit(proc.id)
+	}
+}
 
-		// So get that element, and split by newlines. Check if index is 0, and if so, handle first line to include the
-		// 'p' character
+// ipVersionAllowed() checks whether connections of the given IP version should be shown
+func ipVersionAllowed(ipv6 bool, options settings) bool {
+	if ipv6 {
+		return options.showIPv6
+	}
+	return options.showIPv4
+}
 
-		processInfo := strings.Split(connectionSplit[0], "\n")
+// connectionAllowed() checks a parsed connection against the IP version, port and status filters
+func connectionAllowed(conn connection, options settings) bool {
+	if !ipVersionAllowed(conn.ipv6, options) {
+		return false
+	}
 
-		// Patch to prevent a crash when searching with no processes listed
-		if len(processInfo[0]) == 0 {
-			continue
+	// If we have ports to filter by, and neither remote nor local ports are in the filter then it's invalid
+	if len(options.portFilter) > 0 {
+		if !(slices.Contains(options.portFilter, conn.localPort) ||
+			slices.Contains(options.portFilter, conn.remotePort)) {
+			return false
 		}
+	}
 
-		if processIndex == 0 {
-			// remove first character from the first line in processInfo if we're on index 0
-			processInfo[0] = processInfo[0][1:]
-		}
-		pid, err := strconv.Atoi(processInfo[0])
-		if err != nil {
-			return nil, err
-		}
+	if len(options.namespaceFilter) > 0 && !slices.Contains(options.namespaceFilter, conn.namespace) {
+		return false
+	}
 
-		finalCwd := ""
-		// We have the pid, so we can use that to get the CWD.
-		if options.getCwd {
-			cwd, err := getCwd(pid)
-			if err != nil {
-				return nil, err
-			}
-			finalCwd = cwd
+	// Connections without a status (no TST= field) aren't filtered by status
+	if conn.status != "" {
+		// If the port isn't closed OR we have enabled closed ports
+		if conn.status == "CLOSED" && options.showClosed {
+			return false
 		}
+	}
 
-		cmd := processInfo[1][1:]
-
-		// Logic to check if filtering is matched
-		if
-		// If neither search nor nameFilter are enabled
-		(!(len(options.nameFilter) > 0) && (options.searchTerm == "")) ||
-			// If we have a valid name filter but no search term
-			((options.searchTerm == "") && slices.Contains(options.nameFilter, cmd)) ||
-			// if we have a valid search term and no filter
-			(!(len(options.nameFilter) > 0) && strings.Contains(cmd, options.searchTerm)) ||
-			// If we have a filter and search term and both are matched
-			(((len(options.nameFilter) > 0) && (options.searchTerm != "")) && strings.Contains(cmd, options.searchTerm) && slices.Contains(options.nameFilter, cmd)) {
-
-			user := processInfo[2][1:]
-
-			// Now onto handling ports and addresses. Looping through each one to parse it.
-			allConnections := make([]connection, 0)
+	// UDP sockets don't listen, so ones that are bound without being connected to anything count instead
+	if options.listenOnly && !isListening(conn) {
+		return false
+	}
 
-			// Ignore first element in array, as we've already parsed it
-			for _, connectionString := range connectionSplit[1:] {
-				valid := true // Store if the connection is valid based on the parsing settings
+	if !protocolAllowed(conn.protocol, options) {
+		return false
+	}
 
-				tmpConnection := connection{}
+	if options.exposedOnly && !isExposed(conn) {
+		return false
+	}
 
-				connectionInfo := strings.Split(connectionString, "\n")
-				// A port string will consist of a file descriptor (unused), a connection type (TCP or UDP), the
-				// information on the connection (localAddress:localPort->remoteAddress:remotePort), the connection status
-				// (established, listening, closed, or an empty field), and size of the read/send buffers (unused).
+	return true
+}
 
-				// Loop through each property in the connection info
+// protocolAllowed() checks a connection's protocol against the protocol filter
+func protocolAllowed(protocol string, options settings) bool {
+	return len(options.protocolFilter) == 0 || slices.Contains(options.protocolFilter, strings.ToLower(protocol))
+}
 
-				// First property is always the IP Version
-				tmpConnection.ipv6 = connectionInfo[0] == "IPv6"
-				if !options.showIPv6 && tmpConnection.ipv6 {
-					valid = false
-					continue
-				}
+// The protocols that can be filtered by, in the order P switches between them
+var protocolNames = []string{"tcp", "udp"}
 
-				if !options.showIPv4 && !tmpConnection.ipv6 {
-					valid = false
-					continue
-				}
+// udpState() gets the state to show for a UDP socket, as UDP doesn't have states and lsof doesn't give it one. Like in
+// `ss`, sockets that are only bound are UNCONN, and ones that have been connected to a remote address are CONNECTED.
+func udpState(conn connection) string {
+	if conn.remoteAddress == "" {
+		return "UNCONN"
+	}
+	return "CONNECTED"
+}
 
-				for _, connectionProperty := range connectionInfo[1:] {
-					if len(connectionProperty) > 0 {
-
-						switch string(connectionProperty[0]) {
-						// Switch-case for each identifier (with an additional nested switch-case for the "T**= options)
-
-						case "n":
-							// n: Local and remote addresses and ports.
-							if connectionProperty[1:] == "*:*" {
-								// *:* usually indicates some unimportant connection, so we just make that connection invalid
-								// This might be wrong! If you want to submit an issue about this, then feel free!
-								valid = false
-
-							} else {
-
-								splitLocalAndRemote := strings.Split(connectionProperty[1:], "->")
-								// If there is a ->, then there is a clear local and remote connection
-
-								if len(splitLocalAndRemote) > 1 {
-									var splitLocalAddressAndPort []string
-									var splitRemoteAddressAndPort []string
-
-									if tmpConnection.ipv6 {
-										// IPv6 parsing algorithm
-										// Should only be 2 elements in that array: local and remote addr:port pairs
-										splitLocalAddressAndPort = strings.Split(splitLocalAndRemote[0][1:], "]:")
-										splitRemoteAddressAndPort = strings.Split(splitLocalAndRemote[1][1:], "]:")
-										if len(splitLocalAddressAndPort) < 2 {
-											splitLocalAddressAndPort = strings.Split(splitLocalAndRemote[0], ":")
-										} else {
-											splitLocalAddressAndPort[0] = "[" + splitLocalAddressAndPort[0] + "]"
-										}
-
-										if len(splitRemoteAddressAndPort) < 2 {
-											splitRemoteAddressAndPort = strings.Split(splitLocalAndRemote[1], ":")
-										} else {
-											splitRemoteAddressAndPort[0] = "[" + splitRemoteAddressAndPort[0] + "]"
-										}
-
-									} else {
-										// IPv4 parsing algorithm
-										// Should only be 2 elements in that array: local and remote addr:port pairs
-										splitLocalAddressAndPort = strings.Split(splitLocalAndRemote[0], ":")
-										splitRemoteAddressAndPort = strings.Split(splitLocalAndRemote[1], ":")
-									}
-
-									// Set the struct's data to the parsed output
-									tmpConnection.localAddress = splitLocalAddressAndPort[0]
-									tmpConnection.localPort = splitLocalAddressAndPort[1]
-									tmpConnection.remoteAddress = splitRemoteAddressAndPort[0]
-									tmpConnection.remotePort = splitRemoteAddressAndPort[1]
-
-									// outbound connection, so use remote port for friendly name
-									if _, exists := serviceNames[tmpConnection.remotePort]; exists {
-										tmpConnection.remoteName = serviceNames[tmpConnection.remotePort]
-									}
-
-								} else {
-									// if not, then assume we're looking at a local port and address
-									// Note here, that might be an incorrect assumption, please correct me if I'm wrong :)
-									var splitLocalAddressAndPort []string
-									if tmpConnection.ipv6 {
-										// IPv6 algorithm
-										splitLocalAddressAndPort = strings.Split(splitLocalAndRemote[0][1:], "]:")
-										if len(splitLocalAddressAndPort) < 2 {
-											splitLocalAddressAndPort = strings.Split(splitLocalAndRemote[0], ":")
-										} else {
-											splitLocalAddressAndPort[0] = "[" + splitLocalAddressAndPort[0] + "]"
-										}
-
-									} else {
-										// IPv4 algorithm
-										splitLocalAddressAndPort = strings.Split(splitLocalAndRemote[0], ":")
-									}
-									tmpConnection.localAddress = splitLocalAddressAndPort[0]
-
-									// As localPort is a string, we can handle ports like '*' without conversions.
-									tmpConnection.localPort = splitLocalAddressAndPort[1]
-
-									// friendly port name is local port as process is listening on this port
-									if _, exists := serviceNames[tmpConnection.localPort]; exists {
-										tmpConnection.localName = serviceNames[tmpConnection.localPort]
-									}
-
-								}
-							}
-
-							// Check validity with ports
-							// If we have ports to filter by
-							if len(options.portFilter) > 0 {
-
-								// If neither remote nor local ports are in the filter then it's invalid
-								if !(slices.Contains(options.portFilter, tmpConnection.localPort) ||
-									slices.Contains(options.portFilter, tmpConnection.remotePort)) {
-									valid = false
-								}
-							}
-
-							break
-
-						case "T":
-							if connectionProperty[0:4] == "TST=" {
-								// TST= : Connection status
-								tmpConnection.status = strings.ToTitle(connectionProperty[4:])
-
-								// If the port isn't closed OR we have enabled closed ports
-								if connectionProperty[4:] == "CLOSED" && options.showClosed {
-									valid = false
-								}
-								if options.listenOnly && connectionProperty[4:] != "LISTEN" {
-									valid = false
-								}
-							}
-							break
-
-						case "P":
-							// Get protocol
-							tmpConnection.protocol = connectionProperty[1:]
-							break
-						}
-					}
+// filterProcesses() applies the same filtering as parseLsof() to a slice of processes that has already been parsed,
+// such as one loaded from a recording. Processes left without any connections are dropped.
+func filterProcesses(processes []process, options settings) []process {
+	filtered := selectConnections(processes, func(proc process, conn connection) bool {
+		return matchesNameFilter(proc.name, options) && processAllowed(proc, options) &&
+			connectionAllowed(conn, options)
+	})
 
-				}
+	if options.sortBy != "" {
+		sortProcesses(filtered, options.sortBy, options.sortDescending)
+	}
+	if options.groupByContainer {
+		groupByContainer(filtered)
+	}
+	return filtered
+}
 
-				// That connection has been parsed! Time to add it to the slice.
-				if valid {
-					allConnections = append(allConnections, tmpConnection) // Add the connection to the slice
-				}
-			}
+// selectConnections() keeps only the connections that match, dropping any processes left without a connection
+func selectConnections(processes []process, matches func(proc process, conn connection) bool) []process {
+	selected := make([]process, 0)
 
-			// All elements of a process have now been parsed, so create a new process struct with that information and
-			// append it to the allProcesses slice.
-
-			// If the process still has a valid connection in it.
-			if len(allConnections) > 0 {
-				// Then add it to the slice
-				allProcesses = append(allProcesses, process{
-					id:          pid,
-					name:        cmd,
-					username:    user,
-					directory:   finalCwd,
-					connections: allConnections,
-				})
+	for _, proc := range processes {
+		connections := make([]connection, 0)
+		for _, conn := range proc.connections {
+			if matches(proc, conn) {
+				connections = append(connections, conn)
 			}
+		}
 
+		if len(connections) > 0 {
+			proc.connections = connections
+			selected = append(selected, proc)
 		}
 	}
 
-	// Gone through all processes, so now return the final slice of process structs
-	return allProcesses, nil
+	return selected
 }
 
 // formatLsof() takes the slice of process structs given and converts to the table rows that get rendered
@@ -667,6 +778,18 @@ func formatLsof(processes []process, options settings) ([]table.Row, []int, erro
 					}
 					break
 
+				case "Container":
+					if connIndex == 0 {
+						value = containerLabel(proc)
+					}
+					break
+
+				case "Unit":
+					if connIndex == 0 {
+						value = proc.unit
+					}
+					break
+
 				case "Protocol":
 					value = conn.protocol
 					break
@@ -723,6 +846,44 @@ func formatLsof(processes []process, options settings) ([]table.Row, []int, erro
 					value = strings.ToTitle(conn.status)
 					break
 
+				case "Namespace":
+					value = conn.namespace
+					break
+
+				case "Exposure":
+					value = string(classifyExposure(conn))
+					break
+
+				case "Rx":
+					if conn.rateKnown {
+						value = formatRate(conn.rxRate)
+					}
+					break
+				case "Tx":
+					if conn.rateKnown {
+						value = formatRate(conn.txRate)
+					}
+					break
+
+				case "Total Rx":
+					if connIndex == 0 {
+						rx, _ := processRate(proc)
+						value = formatRate(rx)
+					}
+					break
+				case "Total Tx":
+					if connIndex == 0 {
+						_, tx := processRate(proc)
+						value = formatRate(tx)
+					}
+					break
+
+				case "History":
+					if connIndex == 0 {
+						value = history.sparkline(proc.id)
+					}
+					break
+
 				}
 				row[columnIndex] = value
 
@@ -738,11 +899,7 @@ func formatLsof(processes []process, options settings) ([]table.Row, []int, erro
 // Func to create a command that will terminate a given process ID
 func terminateProcess(id int) tea.Cmd {
 	return func() tea.Msg {
-		pid := id
-		cmd := exec.Command("kill", strconv.Itoa(pid))
-
-		// Terminate the process with that ID. Don't care about the output, so just ignore it
-		err := cmd.Run()
+		err := signalProcess(id, "")
 
 		if err != nil {
 			return errMsg{err}
@@ -751,20 +908,132 @@ func terminateProcess(id int) tea.Cmd {
 	}
 }
 
+// signalProcess() sends a signal to a process with kill. An empty signal sends kill's default, SIGTERM.
+func signalProcess(pid int, signal string) error {
+	args := []string{strconv.Itoa(pid)}
+	if signal != "" {
+		args = append([]string{"-s", signal}, args...)
+	}
+	cmd := exec.Command("kill", args...)
+
+	// Signal the process with that ID. Don't care about the output, so just ignore it
+	return cmd.Run()
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // All the stuff relating to the bubbletea TUI. This includes the Init, Update, and View functions.
 
 func (m model) Init() tea.Cmd {
+	// When replaying a recording, start with the first snapshot instead
+	if len(m.replay) > 0 {
+		return replayFrame(m.replay[m.frame], m.settings)
+	}
+
 	// When we first run, we want to get all the processes currently running
 	return checkProcesses(m.settings)
 }
 
+// selectedProcess() gets the process that the highlighted row belongs to, using the start of each process' set of rows
+func (m model) selectedProcess() (process, bool) {
+	cursor := m.table.Cursor()
+
+	for i := len(m.rowStarts) - 1; i >= 0; i-- {
+		if m.rowStarts[i] <= cursor && i < len(m.processes) {
+			return m.processes[i], true
+		}
+	}
+	return process{}, false
+}
+
+// jumpToProcess() moves the highlight to the first row of the next process, or back to the start of the process (or
+// the one before it if already there), skipping over the rows for a process's other connections
+func (m *model) jumpToProcess(forward bool) {
+	cursor := m.table.Cursor()
+	rows := countRows(m.processes)
+
+	if forward {
+		for _, start := range m.rowStarts {
+			if start > cursor && start < rows {
+				m.table.MoveDown(start - cursor)
+				return
+			}
+		}
+		return
+	}
+
+	for i := len(m.rowStarts) - 1; i >= 0; i-- {
+		if m.rowStarts[i] < cursor {
+			m.table.MoveUp(cursor - m.rowStarts[i])
+			return
+		}
+	}
+}
+
+// rerender() re-filters the data currently on screen after the settings have changed, without running lsof again
+func (m model) rerender() tea.Cmd {
+	if len(m.replay) > 0 {
+		return replayFrame(m.replay[m.frame], m.settings)
+	}
+	return rerenderProcesses(m.collected, m.namespaced, m.warning, m.settings)
+}
+
+// setScreen() switches to another screen. The peer keys are only turned on for the peers screen, so they don't show up
+// in the help anywhere else.
+func (m *model) setScreen(next screen) {
+	m.screen = next
+	m.openPeer = ""
+	m.keys.Select.SetEnabled(next == peersScreen)
+	m.updatePeers()
+}
+
+// updatePeers() groups the processes in the table by remote peer, and fills in the peer tables
+func (m *model) updatePeers() {
+	m.peers = groupPeers(m.processes, m.settings)
+	m.peerTable.SetRows(peerRows(m.peers))
+	if len(m.peers) > 0 && m.peerTable.Cursor() >= len(m.peers) {
+		m.peerTable.SetCursor(len(m.peers) - 1)
+	}
+
+	// The peer that's open might have gone away after a refresh, so go back to the list if it has
+	if m.openPeer == "" {
+		return
+	}
+	for _, peer := range m.peers {
+		if peer.label == m.openPeer {
+			rows := peerConnectionRows(peer, m.settings)
+			m.peerConnectionTable.SetRows(rows)
+			if len(rows) > 0 && m.peerConnectionTable.Cursor() >= len(rows) {
+				m.peerConnectionTable.SetCursor(len(rows) - 1)
+			}
+			return
+		}
+	}
+	m.openPeer = ""
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // Update function. Handles msgs and returns cmds for tea to run
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var updated tea.Model
+	var cmd tea.Cmd
+
+	if mouse, isMouse := msg.(tea.MouseMsg); isMouse {
+		updated, cmd = m.handleMouse(mouse)
+	} else {
+		updated, cmd = m.update(msg)
+	}
+
+	// Keep track of where the tables have scrolled to, so clicks land on the right row
+	next := updated.(model)
+	next.followCursors()
+	return next, cmd
+}
+
+// update() handles everything but the mouse
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
@@ -773,7 +1042,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.table.SetRows(msg.rows) // Convert the array of process structs to text for use in rendering
 		m.rowStarts = msg.ends    // The starts of each process's set of rows
 		m.processes = msg.processes
-		m.lsofOut = msg.raw
+		m.collected = msg.collected
+		m.namespaced = msg.namespaced
+		m.warning = msg.warning
+		if m.settings.namespaces {
+			m.namespaces = namespaceList(msg.namespaced)
+		}
+		m.updatePeers()
 		return m, nil
 
 	case terminateMsg:
@@ -786,31 +1061,41 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tea.WindowSizeMsg:
 		m.help.Width = msg.Width
+		m.windowHeight = msg.Height
 
 	case tea.KeyMsg:
-		if m.settings.displaySearch {
+		if m.pending != nil {
+			// Only run the action if it's confirmed, and any other key cancels it
+			request := *m.pending
+			m.pending = nil
+			if confirmed(msg.String()) {
+				return m, unitAction(m.systemctl, request.action, request.proc)
+			}
+			return m, nil
+
+		} else if m.settings.displaySearch {
 			// Ignore other keys if in search mode
 			switch {
-			case key.Matches(msg, keys.Search):
+			case key.Matches(msg, m.keys.Search):
 				m.textInput.Blur()
 				m.table.Focus()
 
 				m.settings.displaySearch = !m.settings.displaySearch
 
-				return m, rerenderProcesses(m.lsofOut, m.settings)
+				return m, m.rerender()
 
-			case key.Matches(msg, keys.Escape):
+			case key.Matches(msg, m.keys.Escape):
 				m.textInput.Blur()
 				m.table.Focus()
 
 				m.settings.displaySearch = false
 
-				return m, rerenderProcesses(m.lsofOut, m.settings)
+				return m, m.rerender()
 
 			default:
 				m.textInput, cmd = m.textInput.Update(msg)
 				m.settings.searchTerm = m.textInput.Value()
-				return m, rerenderProcesses(m.lsofOut, m.settings)
+				return m, m.rerender()
 
 			}
 
@@ -819,44 +1104,145 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case key.Matches(msg, m.keys.Refresh):
 				return m, checkProcesses(m.settings)
 
-			case key.Matches(msg, keys.Terminate):
-				// If the read-only option is not enabled
-				if m.settings.readOnly != true {
-					// If there are any processes left:
-					if len(m.processes) > 0 {
-						// Get the id of the currently highlighted process and terminate that process
-						cursor := m.table.Cursor()
-
-						// Use the start of each process' set of rows to get the PID to kill.
-						i := 0
-						for i < (len(m.processes)) {
-							if i >= cursor {
-								// We now have the index of the process in m.processes that we need the PID from stored in variable 'i'
-								return m, terminateProcess(m.processes[i].id)
-							}
-
-							i += 1
-						}
-						// If it breaks, do nothing
-						return m, nil
+			case key.Matches(msg, m.keys.Protocol):
+				// Cycle through showing each protocol on its own, then all of them again
+				next := ""
+				for i, protocol := range protocolNames {
+					if len(m.settings.protocolFilter) == 0 {
+						next = protocol
+						break
+					}
+					if protocol == m.settings.protocolFilter[0] && i+1 < len(protocolNames) {
+						next = protocolNames[i+1]
+						break
+					}
+				}
+
+				if next == "" || len(m.settings.protocolFilter) > 1 {
+					m.settings.protocolFilter = nil
+				} else {
+					m.settings.protocolFilter = []string{next}
+				}
+				return m, m.rerender()
+
+			case key.Matches(msg, m.keys.Namespace):
+				// Cycle through showing each namespace on its own, then all of them again
+				next := ""
+				for i, namespace := range m.namespaces {
+					if len(m.settings.namespaceFilter) == 0 {
+						next = namespace
+						break
+					}
+					if namespace == m.settings.namespaceFilter[0] && i+1 < len(m.namespaces) {
+						next = m.namespaces[i+1]
+						break
 					}
+				}
+
+				if next == "" {
+					m.settings.namespaceFilter = nil
+				} else {
+					m.settings.namespaceFilter = []string{next}
+				}
+				return m, m.rerender()
+
+			case key.Matches(msg, m.keys.Back):
+				// Step backwards through the recording, stopping at the first snapshot
+				if m.frame > 0 {
+					m.frame -= 1
+					return m, m.rerender()
+				}
+				return m, nil
+
+			case key.Matches(msg, m.keys.Forward):
+				// Step forwards through the recording, stopping at the last snapshot
+				if m.frame < len(m.replay)-1 {
+					m.frame += 1
+					return m, m.rerender()
+				}
+				return m, nil
+
+			case key.Matches(msg, m.keys.Stats):
+				if m.screen == statsScreen {
+					m.setScreen(processesScreen)
+				} else {
+					m.setScreen(statsScreen)
+				}
+				return m, nil
+
+			case key.Matches(msg, m.keys.Peers):
+				if m.screen == peersScreen {
+					m.setScreen(processesScreen)
+				} else {
+					m.setScreen(peersScreen)
+				}
+				return m, nil
+
+			case key.Matches(msg, m.keys.Select):
+				// Open the highlighted peer, or go back to the list of peers if one is already open
+				if m.openPeer != "" {
+					m.openPeer = ""
+				} else if cursor := m.peerTable.Cursor(); cursor < len(m.peers) {
+					m.openPeer = m.peers[cursor].label
+				}
+				m.updatePeers()
+				m.peerConnectionTable.GotoTop()
+				return m, nil
+
+			case key.Matches(msg, m.keys.Escape) && m.openPeer != "":
+				m.openPeer = ""
+				m.updatePeers()
+				return m, nil
+
+			case key.Matches(msg, m.keys.NextProcess, m.keys.PreviousProcess):
+				// Each process can have several rows, so these only make sense on the processes screen
+				if m.screen == processesScreen {
+					m.jumpToProcess(key.Matches(msg, m.keys.NextProcess))
+				}
+				return m, nil
+
+			case key.Matches(msg, m.keys.Terminate):
+				// If the read-only option is not enabled, and the selected process can be seen
+				if m.settings.readOnly != true && m.screen == processesScreen {
+					// Get the currently highlighted process and terminate that process
+					if proc, exists := m.selectedProcess(); exists {
+						return m, terminateProcess(proc.id)
+					}
+					// If there are no processes left, do nothing
 					return m, nil
 				} else {
 					return m, nil
 				}
 
-			case key.Matches(msg, keys.Help):
+			case key.Matches(msg, m.keys.StopUnit, m.keys.RestartUnit):
+				// Stopping and restarting units changes things just as much as terminating, so respect read-only mode
+				if m.settings.readOnly || m.screen != processesScreen {
+					return m, nil
+				}
+
+				action := "stop"
+				if key.Matches(msg, m.keys.RestartUnit) {
+					action = "restart"
+				}
+
+				// Ask first, like `pvw who --kill` does
+				if proc, exists := m.selectedProcess(); exists {
+					m.pending = &unitRequest{action: action, proc: proc}
+				}
+				return m, nil
+
+			case key.Matches(msg, m.keys.Help):
 				m.help.ShowAll = !m.help.ShowAll
 				return m, nil
 
-			case key.Matches(msg, keys.Search):
+			case key.Matches(msg, m.keys.Search):
 				m.textInput.Focus()
 				m.table.Blur()
 				m.settings.displaySearch = true
 
 				return m, nil
 
-			case key.Matches(msg, keys.Quit):
+			case key.Matches(msg, m.keys.Quit):
 				return m, tea.Quit
 
 			}
@@ -864,6 +1250,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	}
 
+	// Move around whichever table is on screen
+	if _, isKey := msg.(tea.KeyMsg); isKey && m.screen != processesScreen {
+		if m.screen == peersScreen && m.openPeer != "" {
+			m.peerConnectionTable, cmd = m.peerConnectionTable.Update(msg)
+		} else if m.screen == peersScreen {
+			m.peerTable, cmd = m.peerTable.Update(msg)
+		}
+		return m, cmd
+	}
+
 	m.table, cmd = m.table.Update(msg)
 	return m, cmd
 }
@@ -871,95 +1267,291 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m model) View() string {
 
 	var final string
-	final += baseStyle.Render(m.table.View()) + "\n"
+	switch m.screen {
+	case statsScreen:
+		// Keep the statistics the same height as the table, so the rest of the screen doesn't move
+		height := m.table.Height() + 2
+		stats := lipgloss.NewStyle().Height(height).MaxHeight(height).Render(renderStats(computeStats(m.processes)))
+		final += m.inputStyle.Render(stats) + "\n"
+		break
+
+	case peersScreen:
+		if m.openPeer != "" {
+			rows := colourRows(m.peerConnectionTable.View(), m.openPeerStates(), m.peerConnectionScroll,
+				m.peerConnectionTable.Cursor(), m.settings.theme)
+			final += m.inputStyle.Render(rows) + "\n"
+			final += "Connections to " + m.openPeer + "\n"
+		} else {
+			final += m.inputStyle.Render(m.peerTable.View()) + "\n"
+		}
+		break
+
+	default:
+		rows := colourExposure(m.table.View(), m.settings.columns, rowExposures(m.processes), m.scroll, m.table.Cursor(),
+			m.settings.theme)
+		rows = colourRows(rows, rowStates(m.processes), m.scroll, m.table.Cursor(), m.settings.theme)
+		final += m.inputStyle.Render(rows) + "\n"
+		break
+	}
+
+	if m.settings.namespaces {
+		if len(m.settings.namespaceFilter) > 0 {
+			final += "Network namespace: " + strings.Join(m.settings.namespaceFilter, ", ") + "\n"
+		} else {
+			final += "Network namespace: all\n"
+		}
+	}
+
+	if len(m.settings.protocolFilter) > 0 {
+		final += "Protocol: " + strings.ToUpper(strings.Join(m.settings.protocolFilter, ", ")) + "\n"
+	}
+
+	if m.settings.sortBy != "" && m.screen == processesScreen {
+		order := "ascending"
+		if m.settings.sortDescending {
+			order = "descending"
+		}
+		final += "Sorted by " + m.settings.sortBy + " (" + order + ")\n"
+	}
+
+	if len(m.replay) > 0 {
+		final += fmt.Sprintf("Snapshot %d/%d, recorded %s\n", m.frame+1, len(m.replay),
+			m.replay[m.frame].Time.Format("2006-01-02 15:04:05"))
+	}
 
 	if m.err != nil {
-		final += m.err.Error() + "\n"
+		final += m.settings.theme.error().Render(m.err.Error()) + "\n"
+	}
+	if m.warning != nil {
+		final += m.settings.theme.error().Render(m.warning.Error()) + "\n"
+	}
+
+	if m.pending != nil {
+		final += m.pending.question() + "\n"
 	}
 
 	final += m.textInput.View()
 
-	helpView := m.help.View(m.keys)
+	helpView := m.helpView()
 	height := 17 - strings.Count(final, "\n") - strings.Count(helpView, "\n")
+	if height < 0 {
+		height = 0
+	}
 
 	return "\n" + final + strings.Repeat("\n", height) + helpView
 
 }
 
-func main() {
-	// Start by handling the CLI switches/flags
+// helpView() renders the help, with a legend for the row colours under the full help
+func (m model) helpView() string {
+	helpView := m.help.View(m.keys)
+	if m.help.ShowAll {
+		if legend := stateLegend(m.settings.theme, m.help.Styles.FullSeparator); legend != "" {
+			helpView += "\n" + legend
+		}
+	}
+	return helpView
+}
+
+// displayFlags holds the CLI flags that control which columns are rendered and how connections are filtered. They're
+// shared between the main TUI and any subcommands that render the same table (such as `pvw replay`).
+type displayFlags struct {
 	// Columns to enable (always enable the port column)
-	flagConnStatus := pflag.BoolP("show-status", "s", true, "Show the status of connections")
-	flagProtocol := pflag.BoolP("show-protocol", "P", false, "Show the protocol used in a connection")
-	flagShowAddresses := pflag.BoolP("show-addresses", "a", false, "Show IP addresses in a connection")
-	flagFullConnection := pflag.BoolP("show-full-connection", "C", false, "Show full connection information")
-	flagOwner := pflag.BoolP("show-owner", "o", false, "Show the owner of processes")
-	flagName := pflag.BoolP("show-process-name", "n", false, "Show the name of processes")
-	flagPID := pflag.BoolP("show-process-id", "i", true, "Show the process ID")
-	flagDirectory := pflag.BoolP("show-cwd", "d", false, "Show the process' current working directory")
-	flagAll := pflag.BoolP("show-all", "A", false, "Show all information (equivalent to -PCond flags)")
+	connStatus     *bool
+	protocol       *bool
+	showAddresses  *bool
+	fullConnection *bool
+	owner          *bool
+	name           *bool
+	pid            *bool
+	directory      *bool
+	all            *bool
+	container      *bool
+	namespace      *bool
+	unit           *bool
+	throughput     *bool
+	history        *bool
+	exposure       *bool
 
 	// Process and connection filtering options (used in parseLsof())
-	flagListeningOnly := pflag.BoolP("listen-only", "l", false, "Only show listening ports")
-	flagShowClosed := pflag.BoolP("show-closed", "c", false, "Show closed ports")
-	flagShowProtocolNames := pflag.BoolP("show-proto-names", "N", false, "Show protocol names instead of ports where applicable")
+	listeningOnly     *bool
+	exposedOnly       *bool
+	showClosed        *bool
+	showProtocolNames *bool
 
-	flagShowIPv6 := pflag.BoolP("ipv6", "6", true, "Show IPv6 connections")
-	flagShowIPv4 := pflag.BoolP("ipv4", "4", true, "Show IPv4 connections")
+	showIPv6 *bool
+	showIPv4 *bool
 
 	// Read-only mode (prevents process termination, passed to model)
-	flagReadOnly := pflag.BoolP("read-only", "r", false, "Read-only mode - prevents processes from being terminated in the TUI")
+	readOnly *bool
 
 	// A flag to set a comma separated list of ports to filter by
-	flagPortFilter := pflag.StringSlice("ports", nil, "Port filter - only shows the selected ports. Accepts a list of port numbers, separated by commas.")
+	portFilter *[]string
 
-	// Help command should be built-in, and populates based in usage field in pflag.TypeP()
-	pflag.Parse()
+	// A flag to set a comma separated list of protocols to filter by
+	protocolFilter *[]string
 
-	if !*flagShowIPv6 && !*flagShowIPv4 {
-		fmt.Println("Error running pvw: Neither IPv4 or IPv6 connections have been allowed. Please enable at least one." + "")
-		os.Exit(1)
+	// Container options
+	containerFilter  *[]string
+	groupByContainer *bool
+
+	// systemd unit filter
+	unitFilter *[]string
+
+	// Network namespace options
+	allNamespaces   *bool
+	namespaceFilter *[]string
+
+	// Group remote peers by port as well as address
+	peersByPort *bool
+
+	// The column to sort by
+	sortBy *string
+
+	// Connection history options
+	historyLength *int
+	historyState  *string
+
+	// Turn off the mouse, so the terminal can select text again
+	noMouse *bool
+
+	// Colour options
+	theme   *string
+	noColor *bool
+
+	// Key options
+	keymap *string
+	binds  *[]string
+}
+
+// addDisplayFlags() registers all the column and filtering flags on a flag set
+func addDisplayFlags(flags *pflag.FlagSet) displayFlags {
+	return displayFlags{
+		connStatus:     flags.BoolP("show-status", "s", true, "Show the status of connections"),
+		protocol:       flags.BoolP("show-protocol", "P", false, "Show the protocol used in a connection"),
+		showAddresses:  flags.BoolP("show-addresses", "a", false, "Show IP addresses in a connection"),
+		fullConnection: flags.BoolP("show-full-connection", "C", false, "Show full connection information"),
+		owner:          flags.BoolP("show-owner", "o", false, "Show the owner of processes"),
+		name:           flags.BoolP("show-process-name", "n", false, "Show the name of processes"),
+		pid:            flags.BoolP("show-process-id", "i", true, "Show the process ID"),
+		directory:      flags.BoolP("show-cwd", "d", false, "Show the process' current working directory"),
+		all:            flags.BoolP("show-all", "A", false, "Show all information (equivalent to -PCond flags)"),
+		container:      flags.Bool("show-container", false, "Show the container each process is running in"),
+		unit:           flags.Bool("show-unit", false, "Show the systemd unit each process belongs to"),
+		namespace:      flags.Bool("show-namespace", false, "Show the network namespace of connections (implies --all-namespaces)"),
+		throughput:     flags.Bool("show-throughput", false, "Show how fast each connection and process is receiving (Rx) and sending (Tx) data. Linux only"),
+		exposure:       flags.Bool("show-exposure", false, "Show whether listening ports can be reached from loopback only, one interface, or all interfaces"),
+		history:        flags.Bool("show-history", false, "Show a sparkline of each process' connection count over the last few refreshes"),
+
+		listeningOnly:     flags.BoolP("listen-only", "l", false, "Only show listening ports, including UDP ports that aren't connected to anything"),
+		exposedOnly:       flags.Bool("exposed", false, "Only show listening ports that can be reached from outside this host"),
+		showClosed:        flags.BoolP("show-closed", "c", false, "Show closed ports"),
+		showProtocolNames: flags.BoolP("show-proto-names", "N", false, "Show protocol names instead of ports where applicable"),
+
+		showIPv6: flags.BoolP("ipv6", "6", true, "Show IPv6 connections"),
+		showIPv4: flags.BoolP("ipv4", "4", true, "Show IPv4 connections"),
+
+		readOnly: flags.BoolP("read-only", "r", false, "Read-only mode - prevents processes from being terminated in the TUI"),
+
+		portFilter: flags.StringSlice("ports", nil, "Port filter - only shows the selected ports. Accepts a list of port numbers, separated by commas."),
+
+		protocolFilter: flags.StringSlice("proto", nil, "Protocol filter - only shows connections using the selected protocols (tcp or udp). Press P to switch between them"),
+
+		containerFilter:  flags.StringSlice("container", nil, "Container filter - only shows processes in the selected containers. Accepts a list of container names or IDs, separated by commas."),
+		groupByContainer: flags.Bool("group-by-container", false, "Group processes in the same container together"),
+
+		unitFilter: flags.StringSlice("unit", nil, "Unit filter - only shows processes in the selected systemd units. Accepts a list of unit names, separated by commas."),
+
+		allNamespaces:   flags.Bool("all-namespaces", false, "Show connections in every network namespace, not just pvw's own. Press tab to switch between them"),
+		namespaceFilter: flags.StringSlice("netns", nil, "Network namespace filter - only shows the selected namespaces (implies --all-namespaces). Accepts a list of `ip netns` names or namespace IDs, separated by commas."),
+
+		peersByPort: flags.Bool("peers-by-port", false, "Group remote peers by port (or service name with -N) as well as address on the peers screen"),
+
+		sortBy: flags.String("sort", "", "Sort processes and connections by a column, using its title (like Port). Rates sort highest first, and sorting by them implies --show-throughput"),
+
+		historyLength: flags.Int("history", 20, "Number of refreshes to show in the connection history"),
+		historyState:  flags.String("history-state", "", "Only count connections in this state (like ESTABLISHED) in the connection history"),
+
+		noMouse: flags.Bool("no-mouse", false, "Don't use the mouse in the TUI, for terminals where it gets in the way of selecting text"),
+
+		theme:   flags.String("theme", "auto", "Colour theme: auto, default, dark, light, high-contrast, monochrome, or the path to a JSON theme file"),
+		noColor: flags.Bool("no-color", false, "Don't use any colours (same as setting NO_COLOR)"),
+
+		keymap: flags.String("keymap", "default", "Keys to use in the TUI: default, vim or emacs"),
+		binds:  flags.StringArray("bind", nil, "Change the keys for an action in the TUI, like top=home,g. Can be used more than once"),
 	}
+}
 
-	if *flagAll {
-		*flagPID = true
-		*flagName = true
-		*flagDirectory = true
-		*flagOwner = true
-		*flagProtocol = true
-		*flagFullConnection = true
-		*flagConnStatus = true
+// settings() converts the parsed flags into a settings struct, using nameFilter as the process name filter
+func (f displayFlags) settings(nameFilter []string) (settings, error) {
+	if !*f.showIPv6 && !*f.showIPv4 {
+		return settings{}, errors.New("Neither IPv4 or IPv6 connections have been allowed. Please enable at least one.")
 	}
 
-	// All other args act as a process name filter
-	cmdArgs := pflag.Args()
+	// Rates are only worked out when their columns are shown
+	if slices.Contains(rateColumns, *f.sortBy) {
+		*f.throughput = true
+	}
+
+	if *f.history && *f.historyLength < 1 {
+		return settings{}, errors.New("The connection history needs to be at least 1 refresh long.")
+	}
+
+	// The history column is as wide as the history, but still needs room for its title
+	historyColumnWidth := *f.historyLength
+	if historyColumnWidth < 7 {
+		historyColumnWidth = 7
+	}
+
+	if *f.all {
+		*f.pid = true
+		*f.name = true
+		*f.directory = true
+		*f.owner = true
+		*f.protocol = true
+		*f.fullConnection = true
+		*f.connStatus = true
+	}
 
 	// Create a settings map with columns and bool values. Note that pflag makes the variables pointers,
 	// hence the need for *variable
 
 	addressColumnWidth := 15
-	if *flagShowIPv6 {
+	if *f.showIPv6 {
 		addressColumnWidth = 44
 	}
 
 	columnSettings := map[table.Column]bool{
 		// Process information
-		table.Column{Title: "PID", Width: 5}:        *flagPID,
-		table.Column{Title: "Name", Width: 10}:      *flagName,
-		table.Column{Title: "Directory", Width: 16}: *flagDirectory,
-		table.Column{Title: "Owner", Width: 8}:      *flagOwner,
+		table.Column{Title: "PID", Width: 5}:        *f.pid,
+		table.Column{Title: "Name", Width: 10}:      *f.name,
+		table.Column{Title: "Directory", Width: 16}: *f.directory,
+		table.Column{Title: "Owner", Width: 8}:      *f.owner,
+		table.Column{Title: "Container", Width: 12}: *f.container,
+		table.Column{Title: "Unit", Width: 16}:      *f.unit,
 
 		// Connection information
-		table.Column{Title: "Protocol", Width: 3}:                 *flagProtocol, // Used when not viewing full connection
-		table.Column{Title: "Address", Width: addressColumnWidth}: *flagShowAddresses && !*flagFullConnection,
-		table.Column{Title: "Port", Width: 5}:                     !*flagFullConnection,
+		table.Column{Title: "Protocol", Width: 3}:                 *f.protocol, // Used when not viewing full connection
+		table.Column{Title: "Address", Width: addressColumnWidth}: *f.showAddresses && !*f.fullConnection,
+		table.Column{Title: "Port", Width: 5}:                     !*f.fullConnection,
 		// Used when viewing full connection
-		table.Column{Title: "Local Address", Width: addressColumnWidth}:  *flagFullConnection,
-		table.Column{Title: "Local Port", Width: 5}:                      *flagFullConnection,
-		table.Column{Title: "Remote Address", Width: addressColumnWidth}: *flagFullConnection,
-		table.Column{Title: "Remote Port", Width: 5}:                     *flagFullConnection,
+		table.Column{Title: "Local Address", Width: addressColumnWidth}:  *f.fullConnection,
+		table.Column{Title: "Local Port", Width: 5}:                      *f.fullConnection,
+		table.Column{Title: "Remote Address", Width: addressColumnWidth}: *f.fullConnection,
+		table.Column{Title: "Remote Port", Width: 5}:                     *f.fullConnection,
+
+		table.Column{Title: "Status", Width: 11}:  *f.connStatus,
+		table.Column{Title: "Exposure", Width: 9}: *f.exposure,
+
+		table.Column{Title: "Namespace", Width: 10}: *f.namespace,
 
-		table.Column{Title: "Status", Width: 11}: *flagConnStatus,
+		// Throughput
+		table.Column{Title: "Rx", Width: 10}:       *f.throughput,
+		table.Column{Title: "Tx", Width: 10}:       *f.throughput,
+		table.Column{Title: "Total Rx", Width: 10}: *f.throughput,
+		table.Column{Title: "Total Tx", Width: 10}: *f.throughput,
+
+		table.Column{Title: "History", Width: historyColumnWidth}: *f.history,
 	}
 
 	columnIndexes := []table.Column{
@@ -967,6 +1559,8 @@ func main() {
 		{Title: "Name", Width: 10},
 		{Title: "Directory", Width: 16},
 		{Title: "Owner", Width: 8},
+		{Title: "Container", Width: 12},
+		{Title: "Unit", Width: 16},
 
 		// Connection information
 		{Title: "Protocol", Width: 3},
@@ -981,6 +1575,39 @@ func main() {
 		{Title: "Remote Port", Width: 5},
 
 		{Title: "Status", Width: 11},
+		{Title: "Exposure", Width: 9},
+
+		{Title: "Namespace", Width: 10},
+
+		// Throughput
+		{Title: "Rx", Width: 10},
+		{Title: "Tx", Width: 10},
+		{Title: "Total Rx", Width: 10},
+		{Title: "Total Tx", Width: 10},
+
+		{Title: "History", Width: historyColumnWidth},
+	}
+
+	// Protocols are matched in lowercase, so --proto TCP works too
+	var protocolFilter []string
+	for _, protocol := range *f.protocolFilter {
+		protocol = strings.ToLower(protocol)
+		if !slices.Contains(protocolNames, protocol) {
+			return settings{}, errors.New("Unknown protocol " + protocol + ". Please use tcp or udp.")
+		}
+		protocolFilter = append(protocolFilter, protocol)
+	}
+
+	bindings, err := newKeyMap(*f.keymap, *f.binds)
+	if err != nil {
+		return settings{}, err
+	}
+
+	if *f.sortBy != "" {
+		known := slices.IndexFunc(columnIndexes, func(column table.Column) bool { return column.Title == *f.sortBy }) >= 0
+		if !known || !isSortable(*f.sortBy) {
+			return settings{}, errors.New("Can't sort by " + *f.sortBy + ". Please use the title of a column, like Port.")
+		}
 	}
 
 	// Configure columns to use by looping through columnSettings
@@ -995,10 +1622,53 @@ func main() {
 		}
 	}
 
+	historyLength := 0
+	if *f.history {
+		historyLength = *f.historyLength
+	}
+
+	// Create settings struct for parsing settings and render columns
+	return settings{
+		readOnly:         *f.readOnly,
+		showClosed:       *f.showClosed,
+		listenOnly:       *f.listeningOnly,
+		exposedOnly:      *f.exposedOnly,
+		getCwd:           *f.directory,
+		getContainers:    *f.container || len(*f.containerFilter) > 0 || *f.groupByContainer,
+		groupByContainer: *f.groupByContainer,
+		containerFilter:  *f.containerFilter,
+		getUnits:         *f.unit || len(*f.unitFilter) > 0,
+		unitFilter:       *f.unitFilter,
+		namespaces:       *f.allNamespaces || *f.namespace || len(*f.namespaceFilter) > 0,
+		namespaceFilter:  *f.namespaceFilter,
+		peersByPort:      *f.peersByPort,
+		throughput:       *f.throughput,
+		sortBy:           *f.sortBy,
+		sortDescending:   slices.Contains(descendingColumns, *f.sortBy),
+		historyLength:    historyLength,
+		historyState:     *f.historyState,
+		mouse:            !*f.noMouse,
+		themeName:        *f.theme,
+		noColor:          *f.noColor,
+		keys:             bindings,
+		columns:          columns,
+		nameFilter:       nameFilter,
+		portFilter:       *f.portFilter,
+		protocolFilter:   protocolFilter,
+		searchTerm:       "",
+		displaySearch:    false,
+		serviceNames:     *f.showProtocolNames,
+		showIPv6:         *f.showIPv6,
+		showIPv4:         *f.showIPv4,
+	}, nil
+}
+
+// newTable() creates an empty table with the given columns, styled with the theme's colours and moved around with the
+// keymap's keys
+func newTable(columns []table.Column, colours theme, bindings keyMap) table.Model {
 	// Set to empty, then let commands etc. fill the rows out
 	rows := []table.Row{}
 
-	// Create a new table with the selected columns
 	t := table.New(
 		table.WithColumns(columns),
 		table.WithRows(rows),
@@ -1006,37 +1676,19 @@ func main() {
 		table.WithHeight(10),
 	)
 
-	// Change the default styles of the table
-	s := table.DefaultStyles()
-
-	s.Header = s.Header.
-		BorderStyle(lipgloss.NormalBorder()).
-		BorderForeground(lipgloss.Color("240")).
-		BorderBottom(true).
-		Bold(true)
-
-	s.Selected = s.Selected.
-		Foreground(lipgloss.Color("7")).
-		Background(lipgloss.Color("#33a989")).
-		Bold(false)
+	t.SetStyles(colours.tableStyles())
+	t.KeyMap = bindings.tableKeys()
+	return t
+}
 
-	t.SetStyles(s)
+// newModel() creates the bubbletea model for the table described by the settings
+func newModel(parseAndRenderSettings settings) model {
+	// Create a new table with the selected columns, along with the tables for the peers screen
+	t := newTable(parseAndRenderSettings.columns, parseAndRenderSettings.theme, parseAndRenderSettings.keys)
 
-	// Create settings struct for parsing settings and render columns
-	parseAndRenderSettings := settings{
-		readOnly:      *flagReadOnly,
-		showClosed:    *flagShowClosed,
-		listenOnly:    *flagListeningOnly,
-		getCwd:        *flagDirectory,
-		columns:       columns,
-		nameFilter:    cmdArgs,
-		portFilter:    *flagPortFilter,
-		searchTerm:    "",
-		displaySearch: false,
-		serviceNames:  *flagShowProtocolNames,
-		showIPv6:      *flagShowIPv6,
-		showIPv4:      *flagShowIPv4,
-	}
+	peerColumns, peerConnectionColumns := peerColumns(parseAndRenderSettings)
+	peerTable := newTable(peerColumns, parseAndRenderSettings.theme, parseAndRenderSettings.keys)
+	peerConnectionTable := newTable(peerConnectionColumns, parseAndRenderSettings.theme, parseAndRenderSettings.keys)
 
 	// Create text input area
 	ti := textinput.New()
@@ -1044,39 +1696,132 @@ func main() {
 	ti.Blur()
 	ti.CharLimit = 64
 	ti.Width = 16
+	parseAndRenderSettings.theme.styleInput(&ti)
+
+	helpModel := help.New()
+	helpModel.Styles = parseAndRenderSettings.theme.helpStyles()
+
+	// The snapshot keys only do anything when replaying a recording
+	modelKeys := parseAndRenderSettings.keys
+	modelKeys.Back.SetEnabled(false)
+	modelKeys.Forward.SetEnabled(false)
+
+	// Switching namespaces only does anything when looking in all of them
+	modelKeys.Namespace.SetEnabled(parseAndRenderSettings.namespaces)
+
+	// Opening peers only does anything on the peers screen
+	modelKeys.Select.SetEnabled(false)
 
 	// Create final model struct
-	m := model{
+	return model{
 		table:     t,
 		processes: []process{},
+
+		peerTable:           peerTable,
+		peerConnectionTable: peerConnectionTable,
+
 		err:       nil,
 		settings:  parseAndRenderSettings,
+		systemctl: execSystemctl,
 
 		textInput: ti,
 
-		keys:       keys,
-		help:       help.New(),
-		inputStyle: baseStyle,
+		keys:       modelKeys,
+		help:       helpModel,
+		inputStyle: parseAndRenderSettings.theme.border(),
 	}
+}
 
-	// Run it! (except if we're running on Windows)
+// checkPlatform() makes sure pvw can run on this system, and that lsof is installed
+func checkPlatform() error {
 	if runtime.GOOS == "windows" {
-		fmt.Println("Sorry, pvw is UNIX only right now.")
-	} else {
-		// Check if lsof is installed
-		cmd := exec.Command("/bin/sh", "-c", "command -v lsof")
-		err := cmd.Run()
+		return errors.New("Sorry, pvw is UNIX only right now.")
+	}
 
-		if err != nil {
-			fmt.Println("Error running pvw: lsof command does not exist. Please install lsof with your package manager.")
-			os.Exit(1)
+	// Check if lsof is installed
+	cmd := exec.Command("/bin/sh", "-c", "command -v lsof")
+	err := cmd.Run()
+
+	if err != nil {
+		return errors.New("lsof command does not exist. Please install lsof with your package manager.")
+	}
+	return nil
+}
+
+// subcommands maps the first CLI argument to the function that handles it. Each one is given the remaining arguments
+// and returns the exit code for pvw.
+var subcommands = map[string]func(args []string) int{
+	"record":    runRecord,
+	"replay":    runReplay,
+	"diff":      runDiff,
+	"serve":     runServe,
+	"watch":     runWatch,
+	"wait":      runWait,
+	"who":       runWho,
+	"free-port": runFreePort,
+	"can-bind":  runCanBind,
+	"stats":     runStats,
+	"audit":     runAudit,
+	"baseline":  runBaseline,
+}
+
+func main() {
+	// Subcommands get their own set of flags, so hand over to them before parsing anything
+	if len(os.Args) > 1 {
+		if run, exists := subcommands[os.Args[1]]; exists {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
+	// Start by handling the CLI switches/flags
+	flags := addDisplayFlags(pflag.CommandLine)
+
+	// Print the processes as JSON instead of running the TUI
+	flagJSON := pflag.Bool("json", false, "Print a JSON snapshot of the processes and their connections, then exit")
+
+	// Help command should be built-in, and populates based in usage field in pflag.TypeP()
+	pflag.Parse()
+
+	// All other args act as a process name filter
+	parseAndRenderSettings, err := flags.settings(pflag.Args())
+	if err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		os.Exit(1)
+	}
 
+	// Run it! (except if we're running on Windows)
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		os.Exit(1)
+	}
+
+	if parseAndRenderSettings.throughput {
+		if err := checkThroughput(); err != nil {
+			fmt.Println("Error running pvw: " + err.Error())
+			os.Exit(1)
 		}
+	}
 
-		if _, err := tea.NewProgram(m).Run(); err != nil {
+	if *flagJSON {
+		processes, err := collectProcesses(parseAndRenderSettings)
+		if err == nil {
+			err = writeSnapshot(os.Stdout, processes)
+		}
+		if err != nil {
 			fmt.Println("Error running pvw: ", err)
 			os.Exit(1)
 		}
+		return
 	}
 
+	if err := parseAndRenderSettings.useTheme(); err != nil {
+		fmt.Println("Error running pvw: ", err)
+		os.Exit(1)
+	}
+	m := newModel(parseAndRenderSettings)
+
+	if _, err := tea.NewProgram(m, programOptions(parseAndRenderSettings)...).Run(); err != nil {
+		fmt.Println("Error running pvw: ", err)
+		os.Exit(1)
+	}
 }
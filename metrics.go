@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Prometheus metrics
+// Served at /metrics by `pvw serve --metrics-listen`, in the Prometheus text exposition format.
+
+// Every label that can be dropped with --metrics-drop-labels
+var metricLabels = []string{"process", "user", "state", "protocol", "address", "port", "remote_address"}
+
+// The options that control which labels get exported
+type metricsOptions struct {
+	dropLabels    []string // Labels to leave out. Series that only differed by a dropped label get added together
+	remoteAddress bool     // Whether to add the remote_address label to pvw_connections
+}
+
+// isMetricLabel() checks whether a label name is one that pvw exports
+func isMetricLabel(label string) bool {
+	return slices.Contains(metricLabels, label)
+}
+
+// A metric family that's being built up. Series with the same label values are added together.
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string   // The Prometheus metric type, such as gauge or counter
+	labels []string // The names of the labels, with any dropped ones removed
+
+	values map[string]float64  // The value of each series, keyed by its joined label values
+	series map[string][]string // The label values of each series
+}
+
+// newMetricFamily() creates a metric family, leaving out any labels that have been dropped
+func newMetricFamily(name, help, kind string, labels []string, options metricsOptions) *metricFamily {
+	kept := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if !slices.Contains(options.dropLabels, label) {
+			kept = append(kept, label)
+		}
+	}
+
+	return &metricFamily{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: kept,
+		values: make(map[string]float64),
+		series: make(map[string][]string),
+	}
+}
+
+// add() adds to the series with the given labels. Labels that aren't in the family are ignored.
+func (f *metricFamily) add(labels map[string]string, value float64) {
+	values := make([]string, len(f.labels))
+	for i, label := range f.labels {
+		values[i] = labels[label]
+	}
+
+	key := strings.Join(values, "\x00")
+	f.values[key] += value
+	f.series[key] = values
+}
+
+// write() writes the family in the text exposition format, with the series sorted so the output is stable
+func (f *metricFamily) write(out io.Writer) {
+	fmt.Fprintf(out, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(out, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.values))
+	for key := range f.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		pairs := make([]string, len(f.labels))
+		for i, label := range f.labels {
+			pairs[i] = label + "=\"" + escapeLabelValue(f.series[key][i]) + "\""
+		}
+
+		if len(pairs) > 0 {
+			fmt.Fprintf(out, "%s{%s} %v\n", f.name, strings.Join(pairs, ","), f.values[key])
+		} else {
+			fmt.Fprintf(out, "%s %v\n", f.name, f.values[key])
+		}
+	}
+}
+
+// escapeLabelValue() escapes a label value as required by the text exposition format
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	return strings.ReplaceAll(value, "\n", "\\n")
+}
+
+// writeMetrics() writes every metric from the collector's most recent results
+func (c *collector) writeMetrics(out io.Writer, options metricsOptions) {
+	processes, collected := c.latest()
+
+	c.mu.RLock()
+	duration, collections, failures := c.duration, c.collections, c.failures
+	c.mu.RUnlock()
+
+	connectionLabels := []string{"process", "user", "state", "protocol"}
+	if options.remoteAddress {
+		connectionLabels = append(connectionLabels, "remote_address")
+	}
+
+	connections := newMetricFamily("pvw_connections", "Number of open connections.", "gauge", connectionLabels, options)
+	listening := newMetricFamily("pvw_listening_port", "Ports that are being listened on. Always 1.", "gauge",
+		[]string{"port", "process", "address"}, options)
+
+	for _, proc := range processes {
+		for _, conn := range proc.connections {
+			connections.add(map[string]string{
+				"process":        proc.name,
+				"user":           proc.username,
+				"state":          conn.status,
+				"protocol":       conn.protocol,
+				"remote_address": conn.remoteAddress,
+			}, 1)
+
+			if isListening(conn) {
+				listening.add(map[string]string{
+					"port":    conn.localPort,
+					"process": proc.name,
+					"address": conn.localAddress,
+				}, 1)
+			}
+		}
+	}
+
+	// A listening port is either there or it isn't, so don't count worker processes sharing a socket more than once
+	for key := range listening.values {
+		listening.values[key] = 1
+	}
+
+	connections.write(out)
+	listening.write(out)
+
+	fmt.Fprintln(out, "# HELP pvw_collection_duration_seconds How long the most recent run of lsof took.")
+	fmt.Fprintln(out, "# TYPE pvw_collection_duration_seconds gauge")
+	fmt.Fprintf(out, "pvw_collection_duration_seconds %v\n", duration.Seconds())
+
+	fmt.Fprintln(out, "# HELP pvw_collections_total Number of times lsof has been run.")
+	fmt.Fprintln(out, "# TYPE pvw_collections_total counter")
+	fmt.Fprintf(out, "pvw_collections_total %d\n", collections)
+
+	fmt.Fprintln(out, "# HELP pvw_collection_errors_total Number of times running or parsing lsof has failed.")
+	fmt.Fprintln(out, "# TYPE pvw_collection_errors_total counter")
+	fmt.Fprintf(out, "pvw_collection_errors_total %d\n", failures)
+
+	if !collected.IsZero() {
+		fmt.Fprintln(out, "# HELP pvw_last_collection_timestamp_seconds When lsof last succeeded, as a Unix timestamp.")
+		fmt.Fprintln(out, "# TYPE pvw_last_collection_timestamp_seconds gauge")
+		fmt.Fprintf(out, "pvw_last_collection_timestamp_seconds %d\n", collected.Unix())
+	}
+}
+
+// metricsHandler() creates the HTTP handler for /metrics
+func metricsHandler(c *collector, options metricsOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		out := bufio.NewWriter(w)
+		c.writeMetrics(out, options)
+		out.Flush()
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files in testdata with the current output")
+
+// metricsTestCollector creates a collector with a fixed set of processes and statistics
+func metricsTestCollector() *collector {
+	c := newCollector(settings{})
+	c.processes = []process{
+		{id: 100, name: "nginx", username: "www-data", connections: []connection{
+			{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: "80"},
+			{protocol: "TCP", status: "ESTABLISHED", localAddress: "10.0.0.1", localPort: "80", remoteAddress: "10.0.0.7",
+				remotePort: "50000"},
+			{protocol: "TCP", status: "ESTABLISHED", localAddress: "10.0.0.1", localPort: "80", remoteAddress: "10.0.0.8",
+				remotePort: "50001"},
+		}},
+		// A worker sharing the same listening socket, which shouldn't be counted twice
+		{id: 101, name: "nginx", username: "www-data", connections: []connection{
+			{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: "80"},
+			{protocol: "TCP", status: "ESTABLISHED", localAddress: "10.0.0.1", localPort: "80", remoteAddress: "10.0.0.7",
+				remotePort: "50002"},
+		}},
+		{id: 200, name: "systemd-resolve", username: "systemd-resolve", connections: []connection{
+			{protocol: "UDP", status: "UNCONN", localAddress: "127.0.0.53", localPort: "53"},
+		}},
+		// Label values get escaped
+		{id: 300, name: `odd "name"`, username: `back\slash`, connections: []connection{
+			{protocol: "TCP", status: "LISTEN", localAddress: "127.0.0.1", localPort: "9000"},
+		}},
+	}
+	c.collected = time.Unix(1700000000, 0)
+	c.duration = 250 * time.Millisecond
+	c.collections = 12
+	c.failures = 1
+	return c
+}
+
+func TestWriteMetrics(t *testing.T) {
+	tests := []struct {
+		golden  string
+		options metricsOptions
+	}{
+		{"default.txt", metricsOptions{}},
+		{"drop-labels.txt", metricsOptions{dropLabels: []string{"user", "state", "address"}}},
+		{"remote-address.txt", metricsOptions{remoteAddress: true}},
+		{"remote-address-dropped.txt", metricsOptions{remoteAddress: true, dropLabels: []string{"remote_address"}}},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		metricsTestCollector().writeMetrics(&out, test.options)
+
+		path := filepath.Join("testdata", "metrics", test.golden)
+		if *updateGolden {
+			if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s: metrics don't match:\n%s\nwant:\n%s", test.golden, out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Mouse
+// Clicking a row selects it, the wheel scrolls, clicking a column's header sorts by it, and clicking something in the
+// help does it. Everything is worked out from where View() draws things, so this needs to change along with it.
+
+// How far the table's header and first row are below the top of its border. The border is followed by the header, then
+// the line under the header.
+const (
+	tableHeaderOffset   = 1
+	tableFirstRowOffset = 3
+)
+
+// How far a table has been scrolled. bubbles' table scrolls itself, but doesn't say how far, and it's needed to work
+// out which row was clicked. This follows the same rules as the table does, so that it always agrees with it.
+type tableScroll struct {
+	offset int // The index of the row at the top of the table
+}
+
+// follow() updates the scroll after the table's rows or cursor have changed
+func (s *tableScroll) follow(cursor, rows, height int) {
+	// The table jumps to the bottom when its rows shrink out from under it
+	if s.offset > rows-1 {
+		s.offset = rows - height
+		if s.offset < 0 {
+			s.offset = 0
+		}
+	}
+
+	if cursor >= rows {
+		cursor = rows - 1
+	}
+	if cursor < 0 {
+		return
+	}
+
+	// Moving the cursor off the screen scrolls just far enough to show it again
+	if cursor < s.offset {
+		s.offset = cursor
+	}
+	if cursor > s.offset+height-1 {
+		s.offset = cursor - height + 1
+	}
+}
+
+// programOptions() gets the options to start the TUI with, which turn the mouse on unless --no-mouse was passed.
+// Turning it on stops most terminals from selecting text without holding shift. Clicks are reported by where they are
+// on the terminal, so the mouse also needs the alternate screen, where the view always starts on the first line.
+func programOptions(options settings) []tea.ProgramOption {
+	if !options.mouse {
+		return nil
+	}
+	return []tea.ProgramOption{tea.WithAltScreen(), tea.WithMouseCellMotion()}
+}
+
+// countRows() gets the number of rows the table has for a slice of processes, which is one for every connection
+func countRows(processes []process) int {
+	rows := 0
+	for _, proc := range processes {
+		rows += len(proc.connections)
+	}
+	return rows
+}
+
+// activeTable() gets the table on screen, along with its scroll and how many rows it has. There isn't one on the
+// statistics screen.
+func (m *model) activeTable() (*table.Model, *tableScroll, int) {
+	switch m.screen {
+	case processesScreen:
+		return &m.table, &m.scroll, countRows(m.processes)
+
+	case peersScreen:
+		if m.openPeer == "" {
+			return &m.peerTable, &m.peerScroll, len(m.peers)
+		}
+		for _, peer := range m.peers {
+			if peer.label == m.openPeer {
+				return &m.peerConnectionTable, &m.peerConnectionScroll, peer.connections
+			}
+		}
+	}
+	return nil, nil, 0
+}
+
+// followCursors() keeps the scroll of every table up to date. It's called after every update.
+func (m *model) followCursors() {
+	m.scroll.follow(m.table.Cursor(), countRows(m.processes), m.table.Height())
+	m.peerScroll.follow(m.peerTable.Cursor(), len(m.peers), m.peerTable.Height())
+
+	for _, peer := range m.peers {
+		if peer.label == m.openPeer {
+			m.peerConnectionScroll.follow(m.peerConnectionTable.Cursor(), peer.connections, m.peerConnectionTable.Height())
+		}
+	}
+}
+
+// columnAt() gets the index of the column at a position along the table, if there is one there. Every cell is
+// padded by a space on either side, and the table's border is one character wide.
+func columnAt(columns []table.Column, x int) (int, bool) {
+	left := 1
+	for i, column := range columns {
+		right := left + column.Width + 2
+		if x >= left && x < right {
+			return i, true
+		}
+		left = right
+	}
+	return 0, false
+}
+
+// helpBindingAt() gets the key binding drawn in the help at a position, if there is one there. It lays the help out
+// the same way the help bubble does.
+func (m model) helpBindingAt(x, line int) (key.Binding, bool) {
+	if !m.help.ShowAll {
+		if line != 0 {
+			return key.Binding{}, false
+		}
+
+		left := 0
+		separator := lipgloss.Width(m.help.ShortSeparator)
+		for _, binding := range m.keys.ShortHelp() {
+			if !binding.Enabled() {
+				continue
+			}
+			if left > 0 {
+				left += separator
+			}
+
+			right := left + lipgloss.Width(binding.Help().Key) + 1 + lipgloss.Width(binding.Help().Desc)
+			if x >= left && x < right {
+				return binding, true
+			}
+			left = right
+		}
+		return key.Binding{}, false
+	}
+
+	// The full help is a column for each group of bindings, with the keys and descriptions lined up inside it
+	left := 0
+	separator := lipgloss.Width(m.help.FullSeparator)
+	for _, group := range m.keys.FullHelp() {
+		var enabled []key.Binding
+		keyWidth, descWidth := 0, 0
+		for _, binding := range group {
+			if binding.Enabled() {
+				enabled = append(enabled, binding)
+				keyWidth = maxInt(keyWidth, lipgloss.Width(binding.Help().Key))
+				descWidth = maxInt(descWidth, lipgloss.Width(binding.Help().Desc))
+			}
+		}
+		if len(enabled) == 0 {
+			continue
+		}
+
+		right := left + keyWidth + 1 + descWidth
+		if x >= left && x < right && line < len(enabled) {
+			return enabled[line], true
+		}
+		left = right + separator
+	}
+	return key.Binding{}, false
+}
+
+// viewOrigin() gets the line of the view that's on the terminal's first line. When the view is taller than the
+// terminal, bubbletea only draws the bottom of it, so the lines above that are off the screen.
+func viewOrigin(viewLines, windowHeight int) int {
+	if windowHeight > 0 && viewLines > windowHeight {
+		return viewLines - windowHeight
+	}
+	return 0
+}
+
+// tableTop() gets the line of the view the table's top border is on, by finding it in the rendered lines. Anything
+// shown above the table moves it down, so this is worked out every time rather than assumed. It's -1 if there isn't a
+// table on screen.
+func tableTop(lines []string) int {
+	corner := lipgloss.NormalBorder().TopLeft
+	for i, line := range lines {
+		if strings.Contains(line, corner) {
+			return i
+		}
+	}
+	return -1
+}
+
+// clickable() checks whether a key binding can be used by clicking on it in the help. Terminating a process or
+// stopping its unit can't be undone, so those need a key to be pressed rather than a single stray click.
+func (m model) clickable(binding key.Binding) bool {
+	for _, destructive := range []key.Binding{m.keys.Terminate, m.keys.StopUnit, m.keys.RestartUnit} {
+		if slices.Equal(binding.Keys(), destructive.Keys()) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyMsgFor() creates the key press that triggers a key binding, so clicking on a binding in the help does the same
+// thing as pressing it
+func keyMsgFor(binding key.Binding) tea.KeyMsg {
+	return keyMsgNamed(binding.Keys()[0])
+}
+
+// keyMsgNamed() creates the key press with a name, like "enter", "alt+v" or "q"
+func keyMsgNamed(name string) tea.KeyMsg {
+	if strings.HasPrefix(name, "alt+") && len(name) > len("alt+") {
+		msg := keyMsgNamed(strings.TrimPrefix(name, "alt+"))
+		msg.Alt = true
+		return msg
+	}
+
+	// Named keys (like enter and ctrl+c) have their own types, so find the one with the same name. Everything else is
+	// typed as text.
+	for keyType := tea.KeyType(-100); keyType < 128; keyType++ {
+		if msg := (tea.KeyMsg{Type: keyType}); keyType != tea.KeyRunes && msg.String() == name {
+			return msg
+		}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(name)}
+}
+
+// sortByColumn() sorts the table by a column, or reverses the sort if it's already sorted by it
+func (m model) sortByColumn(column string) (tea.Model, tea.Cmd) {
+	if !isSortable(column) {
+		return m, nil
+	}
+
+	if m.settings.sortBy == column {
+		m.settings.sortDescending = !m.settings.sortDescending
+	} else {
+		m.settings.sortBy = column
+		m.settings.sortDescending = slices.Contains(descendingColumns, column)
+	}
+	return m, m.rerender()
+}
+
+// handleMouse() handles clicks and the scroll wheel
+func (m model) handleMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	active, scroll, rows := m.activeTable()
+
+	switch msg.Type {
+	case tea.MouseWheelUp:
+		if active != nil {
+			active.MoveUp(1)
+		}
+		return m, nil
+
+	case tea.MouseWheelDown:
+		if active != nil {
+			active.MoveDown(1)
+		}
+		return m, nil
+
+	case tea.MouseLeft:
+		break
+
+	default:
+		return m, nil
+	}
+
+	// Work out which line of the view was clicked on
+	lines := strings.Split(m.View(), "\n")
+	y := msg.Y + viewOrigin(len(lines), m.windowHeight)
+
+	// Clicking the help, which is at the very bottom
+	helpTop := len(lines) - 1 - strings.Count(m.helpView(), "\n")
+	if y >= helpTop {
+		if binding, exists := m.helpBindingAt(msg.X, y-helpTop); exists && m.clickable(binding) {
+			return m.update(keyMsgFor(binding))
+		}
+		return m, nil
+	}
+
+	// Typing in the search bar takes priority over everything on the table
+	if active == nil || m.settings.displaySearch {
+		return m, nil
+	}
+
+	top := tableTop(lines)
+	if top < 0 {
+		return m, nil
+	}
+	headerLine, firstRow := top+tableHeaderOffset, top+tableFirstRowOffset
+
+	if y == headerLine && m.screen == processesScreen {
+		if column, exists := columnAt(m.settings.columns, msg.X); exists {
+			return m.sortByColumn(m.settings.columns[column].Title)
+		}
+		return m, nil
+	}
+
+	if row := scroll.offset + y - firstRow; y >= firstRow && y < firstRow+active.Height() && row < rows {
+		active.SetCursor(row)
+	}
+	return m, nil
+}
+
+// maxInt() gets the larger of two ints
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/pflag"
+)
+
+// mouseModel creates a model showing a process for each port, in a terminal of a given height
+func mouseModel(t *testing.T, ports []string, windowHeight int) model {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	displayOptions := addDisplayFlags(flags)
+	if err := flags.Parse([]string{"--no-color"}); err != nil {
+		t.Fatal(err)
+	}
+	options, err := displayOptions.settings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var processes []process
+	for i, port := range ports {
+		processes = append(processes, process{id: 100 + i, name: "server", username: "root", connections: []connection{
+			{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: port},
+		}})
+	}
+	rows, ends, err := formatLsof(processes, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updated tea.Model = newModel(options)
+	updated, _ = updated.Update(tea.WindowSizeMsg{Width: 120, Height: windowHeight})
+	updated, _ = updated.Update(processesMsg{processes: processes, rows: rows, ends: ends})
+	return updated.(model)
+}
+
+// screenLine() gets the line of the terminal some text is drawn on, after the lines that don't fit have been cut off
+// the top of the view
+func screenLine(t *testing.T, m model, text string) int {
+	lines := strings.Split(m.View(), "\n")
+	for i, line := range lines {
+		if strings.Contains(line, text) {
+			return i - viewOrigin(len(lines), m.windowHeight)
+		}
+	}
+	t.Fatalf("%q isn't in the view:\n%s", text, m.View())
+	return 0
+}
+
+func TestClickRow(t *testing.T) {
+	ports := []string{"8001", "8002", "8003", "8004", "8005"}
+
+	// A tall terminal shows the whole view, and a short one cuts the top of it off, so the view starts above the
+	// terminal's first line
+	for _, windowHeight := range []int{100, 16} {
+		for i, port := range ports {
+			m := mouseModel(t, ports, windowHeight)
+			y := screenLine(t, m, " "+port+" ")
+
+			updated, _ := m.Update(tea.MouseMsg{X: 3, Y: y, Type: tea.MouseLeft})
+			if cursor := updated.(model).table.Cursor(); cursor != i {
+				t.Errorf("height %d: clicking line %d selected row %d, want %d", windowHeight, y, cursor, i)
+			}
+		}
+	}
+}
+
+func TestClickHeader(t *testing.T) {
+	for _, windowHeight := range []int{100, 18} {
+		m := mouseModel(t, []string{"8001", "8002", "8003", "8004", "8005"}, windowHeight)
+		y := screenLine(t, m, m.settings.columns[0].Title)
+
+		updated, _ := m.Update(tea.MouseMsg{X: 3, Y: y, Type: tea.MouseLeft})
+		if sortBy := updated.(model).settings.sortBy; sortBy != m.settings.columns[0].Title {
+			t.Errorf("height %d: clicking the header sorted by %q, want %q", windowHeight, sortBy,
+				m.settings.columns[0].Title)
+		}
+	}
+}
+
+func TestViewOrigin(t *testing.T) {
+	tests := []struct {
+		viewLines, windowHeight, want int
+	}{
+		{10, 0, 0},
+		{10, 24, 0},
+		{10, 10, 0},
+		{30, 24, 6},
+	}
+
+	for _, test := range tests {
+		if got := viewOrigin(test.viewLines, test.windowHeight); got != test.want {
+			t.Errorf("viewOrigin(%d, %d) = %d, want %d", test.viewLines, test.windowHeight, got, test.want)
+		}
+	}
+}
+
+func TestProgramOptions(t *testing.T) {
+	if got := len(programOptions(settings{mouse: true})); got != 2 {
+		t.Errorf("programOptions() with the mouse gave %d options, want the alternate screen and the mouse", got)
+	}
+	if got := programOptions(settings{}); got != nil {
+		t.Errorf("programOptions() without the mouse = %v, want none", got)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Network namespaces
+// lsof only understands sockets in its own network namespace, so sockets in other namespaces (like containers, or
+// ones made with `ip netns`) are read straight from /proc instead. This only works on Linux, and needs root to see
+// other users' processes.
+
+// The states in /proc/net/tcp, as hex numbers, with the names lsof uses for them
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSED",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// The files in /proc/<pid>/net that list sockets, and the protocol and IP version of each one
+var procNetFiles = []struct {
+	name     string
+	protocol string
+	ipv6     bool
+}{
+	{"tcp", "TCP", false},
+	{"tcp6", "TCP", true},
+	{"udp", "UDP", false},
+	{"udp6", "UDP", true},
+}
+
+// The directory `ip netns` keeps its named namespaces in
+const namedNamespaceDirectory = "/run/netns"
+
+// getNamespaceID() gets the ID of the network namespace a process is in, from the /proc/<pid>/ns/net link (which
+// looks like net:[4026531840]). Returns an empty string if it can't be read.
+func getNamespaceID(pid string) string {
+	link, err := os.Readlink("/proc/" + pid + "/ns/net")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(link, "net:["), "]")
+}
+
+// getNamedNamespaces() gets the files for the namespaces made with `ip netns`, keyed by their name
+func getNamedNamespaces() map[string]os.FileInfo {
+	named := make(map[string]os.FileInfo)
+
+	entries, err := os.ReadDir(namedNamespaceDirectory)
+	if err != nil {
+		return named
+	}
+
+	for _, entry := range entries {
+		if info, err := os.Stat(filepath.Join(namedNamespaceDirectory, entry.Name())); err == nil {
+			named[entry.Name()] = info
+		}
+	}
+	return named
+}
+
+// namespaceLabel() gets the text used for a process' namespace in the table: its `ip netns` name if it has one, or
+// its ID. The files in /run/netns are the namespaces themselves, so they're compared with the process' namespace.
+func namespaceLabel(pid string, named map[string]os.FileInfo) string {
+	if info, err := os.Stat("/proc/" + pid + "/ns/net"); err == nil {
+		for name, namedInfo := range named {
+			if os.SameFile(info, namedInfo) {
+				return name
+			}
+		}
+	}
+	return getNamespaceID(pid)
+}
+
+// getOwnNamespace() gets the label of the namespace pvw (and so lsof) is running in
+func getOwnNamespace() string {
+	return namespaceLabel("self", getNamedNamespaces())
+}
+
+// inOwnNamespace() checks whether a process is in the same namespace as pvw. Processes that can't be checked are
+// assumed to be, so that they're still shown by lsof.
+func inOwnNamespace(pid int) bool {
+	id := getNamespaceID(strconv.Itoa(pid))
+	return id == "" || id == getNamespaceID("self")
+}
+
+// The byte order of the host pvw is running on, which the kernel uses for the addresses in /proc/net/tcp
+var hostByteOrder = func() binary.ByteOrder {
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// parseProcAddress() converts an address from /proc/net/tcp (like 0100007F:1F90) into the address and port lsof
+// would show for it. The address is printed as 32 bit words, each read in the host's byte order.
+func parseProcAddress(raw string, ipv6 bool) (string, string) {
+	hexAddress, hexPort, found := strings.Cut(raw, ":")
+	if !found {
+		return "", ""
+	}
+
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", ""
+	}
+
+	bytes, err := hex.DecodeString(hexAddress)
+	if err != nil || len(bytes)%4 != 0 {
+		return "", ""
+	}
+	for i := 0; i < len(bytes); i += 4 {
+		hostByteOrder.PutUint32(bytes[i:], binary.BigEndian.Uint32(bytes[i:]))
+	}
+
+	ip := net.IP(bytes)
+	address := ip.String()
+	if ip.IsUnspecified() {
+		address = "*"
+	} else if ipv6 {
+		address = "[" + address + "]"
+	}
+
+	return address, strconv.FormatUint(port, 10)
+}
+
+// readProcNet() reads the sockets in one of the /proc/<pid>/net files, keyed by their inode
+func readProcNet(path, protocol string, ipv6 bool) map[string]connection {
+	sockets := make(map[string]connection)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sockets
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// The first line is a header
+	for _, line := range lines[1:] {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+
+		conn := connection{protocol: protocol, ipv6: ipv6}
+		conn.localAddress, conn.localPort = parseProcAddress(fields[1], ipv6)
+		conn.remoteAddress, conn.remotePort = parseProcAddress(fields[2], ipv6)
+
+		// Sockets that aren't connected have a remote address of all zeros, which lsof doesn't show
+		if conn.remotePort == "0" {
+			conn.remoteAddress, conn.remotePort = "", ""
+		}
+
+		// UDP doesn't have states, so it gets the same ones as from lsof
+		if protocol == "TCP" {
+			conn.status = tcpStates[fields[3]]
+		} else if protocol == "UDP" {
+			conn.status = udpState(conn)
+		}
+
+		// Same as in parseLsof(): a friendly name for the remote port, or the local port if there's no remote end
+		if conn.remoteAddress != "" {
+			conn.remoteName = serviceNames[conn.remotePort]
+		} else {
+			conn.localName = serviceNames[conn.localPort]
+		}
+
+		sockets[fields[9]] = conn
+	}
+
+	return sockets
+}
+
+// getProcessSockets() gets the inodes of every socket a process has open, from the links in /proc/<pid>/fd
+func getProcessSockets(pid string) []string {
+	entries, err := os.ReadDir("/proc/" + pid + "/fd")
+	if err != nil {
+		return nil
+	}
+
+	var inodes []string
+	for _, entry := range entries {
+		link, err := os.Readlink("/proc/" + pid + "/fd/" + entry.Name())
+		if err == nil && strings.HasPrefix(link, "socket:[") {
+			inodes = append(inodes, strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"))
+		}
+	}
+	return inodes
+}
+
+// getProcessOwner() gets the name and username of a process from /proc
+func getProcessOwner(pid string, usernames map[string]string) (string, string) {
+	name := ""
+	if comm, err := os.ReadFile("/proc/" + pid + "/comm"); err == nil {
+		name = strings.TrimSpace(string(comm))
+	}
+
+	status, err := os.ReadFile("/proc/" + pid + "/status")
+	if err != nil {
+		return name, ""
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		// Uid: real effective saved filesystem
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+
+		uid := fields[1]
+		if _, exists := usernames[uid]; !exists {
+			usernames[uid] = uid
+			if u, err := user.LookupId(uid); err == nil {
+				usernames[uid] = u.Username
+			}
+		}
+		return name, usernames[uid]
+	}
+	return name, ""
+}
+
+// collectNamespaces() reads the sockets of every process that's in a different network namespace to pvw. Each
+// connection is tagged with its namespace, but nothing is filtered, so the result can be re-filtered later.
+func collectNamespaces(options settings) []process {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return []process{}
+	}
+
+	ownNamespace := getNamespaceID("self")
+	named := getNamedNamespaces()
+
+	// Group the processes by namespace, skipping pvw's own as lsof already handles it
+	pidsByNamespace := make(map[string][]string)
+	for _, entry := range entries {
+		pid := entry.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+
+		id := getNamespaceID(pid)
+		if id != "" && id != ownNamespace {
+			pidsByNamespace[id] = append(pidsByNamespace[id], pid)
+		}
+	}
+
+	// Go through the namespaces and their processes in order, so the table doesn't move around between refreshes
+	namespaces := make([]string, 0, len(pidsByNamespace))
+	for id, pids := range pidsByNamespace {
+		namespaces = append(namespaces, id)
+		sort.Slice(pids, func(i, j int) bool { return numericLess(pids[i], pids[j]) })
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return numericLess(namespaces[i], namespaces[j]) })
+
+	usernames := make(map[string]string)
+	processes := make([]process, 0)
+
+	for _, id := range namespaces {
+		pids := pidsByNamespace[id]
+		// Every process in a namespace sees the same sockets, so read them through the first one
+		sockets := make(map[string]connection)
+		label := namespaceLabel(pids[0], named)
+		for _, file := range procNetFiles {
+			for inode, conn := range readProcNet("/proc/"+pids[0]+"/net/"+file.name, file.protocol, file.ipv6) {
+				conn.namespace = label
+				sockets[inode] = conn
+			}
+		}
+
+		for _, pid := range pids {
+			var connections []connection
+			for _, inode := range getProcessSockets(pid) {
+				if conn, exists := sockets[inode]; exists {
+					connections = append(connections, conn)
+				}
+			}
+			if len(connections) == 0 {
+				continue
+			}
+
+			pidNumber, _ := strconv.Atoi(pid)
+			name, username := getProcessOwner(pid, usernames)
+
+			proc := process{id: pidNumber, name: name, username: username, connections: connections}
+			if options.getCwd {
+				// The directory is left empty if it can't be read, like when the process has just exited
+				if cwd, err := getCwd(pidNumber); err == nil {
+					proc.directory = cwd
+				}
+			}
+			lookupProcessDetails(&proc, options)
+			processes = append(processes, proc)
+		}
+	}
+
+	return processes
+}
+
+// numericLess() compares two PIDs or namespace IDs by their numbers, rather than as text
+func numericLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// namespaceList() gets every namespace that has a connection in it, starting with pvw's own
+func namespaceList(processes []process) []string {
+	namespaces := []string{getOwnNamespace()}
+
+	for _, proc := range processes {
+		for _, conn := range proc.connections {
+			found := false
+			for _, namespace := range namespaces {
+				if namespace == conn.namespace {
+					found = true
+					break
+				}
+			}
+			if !found {
+				namespaces = append(namespaces, conn.namespace)
+			}
+		}
+	}
+	return namespaces
+}
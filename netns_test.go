@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+	"testing"
+)
+
+func TestParseProcAddress(t *testing.T) {
+	if hostByteOrder != binary.LittleEndian {
+		t.Skip("the addresses below are written the way a little endian host prints them")
+	}
+
+	tests := []struct {
+		raw         string
+		ipv6        bool
+		wantAddress string
+		wantPort    string
+	}{
+		{"0100007F:1F90", false, "127.0.0.1", "8080"},
+		{"00000000:0016", false, "*", "22"},
+		{"0101A8C0:01BB", false, "192.168.1.1", "443"},
+		{"00000000000000000000000001000000:0050", true, "[::1]", "80"},
+		{"00000000000000000000000000000000:0035", true, "*", "53"},
+		{"0100007F", false, "", ""},
+		{"0100007:1F90", false, "", ""},
+		{"0100007F:XYZ", false, "", ""},
+	}
+
+	for _, test := range tests {
+		address, port := parseProcAddress(test.raw, test.ipv6)
+		if address != test.wantAddress || port != test.wantPort {
+			t.Errorf("parseProcAddress(%q) = %q, %q, want %q, %q", test.raw, address, port, test.wantAddress,
+				test.wantPort)
+		}
+	}
+}
+
+func TestNumericLess(t *testing.T) {
+	ids := []string{"4026532817", "100", "20", "4026531840", "3"}
+	sort.Slice(ids, func(i, j int) bool { return numericLess(ids[i], ids[j]) })
+
+	want := []string{"3", "20", "100", "4026531840", "4026532817"}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("sorted %v, want %v", ids, want)
+		}
+	}
+}
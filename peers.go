@@ -0,0 +1,172 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/table"
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Remote peers
+// Groups connections by the remote end, for the peers screen in the TUI. It's the quickest way to see which clients are
+// hammering a server.
+
+// A remote peer, and the connections to it
+type peerGroup struct {
+	label       string    // The peer's address, and its port too if grouping by port
+	connections int       // The number of connections to the peer
+	processes   []process // The processes connected to the peer, with only the connections to it
+	states      []string  // The states the connections to the peer are in
+}
+
+// peerLabel() gets the label used to group a connection by its remote end
+func peerLabel(conn connection, options settings) string {
+	if !options.peersByPort {
+		return conn.remoteAddress
+	}
+
+	port := conn.remotePort
+	if options.serviceNames && conn.remoteName != "" {
+		port = conn.remoteName
+	}
+	return conn.remoteAddress + ":" + port
+}
+
+// groupPeers() groups every connection that has a remote end by its peer, with the peers with the most connections
+// first. Listening sockets don't have a peer, so they're skipped.
+func groupPeers(processes []process, options settings) []peerGroup {
+	groups := make(map[string]*peerGroup)
+	var order []string
+
+	for _, proc := range processes {
+		for _, conn := range proc.connections {
+			if conn.status == "LISTEN" || conn.remoteAddress == "" {
+				continue
+			}
+
+			label := peerLabel(conn, options)
+			group, exists := groups[label]
+			if !exists {
+				group = &peerGroup{label: label}
+				groups[label] = group
+				order = append(order, label)
+			}
+
+			group.connections += 1
+
+			// Connections from the same process are kept together, in the order they came in
+			last := len(group.processes) - 1
+			if last < 0 || group.processes[last].id != proc.id {
+				peerProc := proc
+				peerProc.connections = nil
+				group.processes = append(group.processes, peerProc)
+				last += 1
+			}
+			group.processes[last].connections = append(group.processes[last].connections, conn)
+
+			state := conn.status
+			if state == "" {
+				state = statsNoState
+			}
+			if !slices.Contains(group.states, state) {
+				group.states = append(group.states, state)
+			}
+		}
+	}
+
+	peers := make([]peerGroup, 0, len(order))
+	for _, label := range order {
+		sort.Strings(groups[label].states)
+		peers = append(peers, *groups[label])
+	}
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		if peers[i].connections != peers[j].connections {
+			return peers[i].connections > peers[j].connections
+		}
+		return peers[i].label < peers[j].label
+	})
+	return peers
+}
+
+// peerColumns() gets the columns of the peers table, and of the table of connections to a single peer
+func peerColumns(options settings) ([]table.Column, []table.Column) {
+	addressWidth := 15
+	if options.showIPv6 {
+		addressWidth = 44
+	}
+
+	peerWidth := addressWidth
+	if options.peersByPort {
+		peerWidth += 6
+	}
+
+	peers := []table.Column{
+		{Title: "Peer", Width: peerWidth},
+		{Title: "Connections", Width: 11},
+		{Title: "Processes", Width: 24},
+		{Title: "States", Width: 24},
+	}
+
+	connections := []table.Column{
+		{Title: "PID", Width: 5},
+		{Title: "Name", Width: 10},
+		{Title: "Local Address", Width: addressWidth},
+		{Title: "Local Port", Width: 5},
+		{Title: "Remote Port", Width: 5},
+		{Title: "Status", Width: 11},
+	}
+
+	return peers, connections
+}
+
+// peerRows() converts the peers to rows for the peers table
+func peerRows(peers []peerGroup) []table.Row {
+	rows := make([]table.Row, 0, len(peers))
+
+	for _, peer := range peers {
+		names := make([]string, 0, len(peer.processes))
+		for _, proc := range peer.processes {
+			names = append(names, processLabel(proc))
+		}
+
+		rows = append(rows, table.Row{
+			peer.label,
+			strconv.Itoa(peer.connections),
+			strings.Join(names, ", "),
+			strings.Join(peer.states, ", "),
+		})
+	}
+	return rows
+}
+
+// peerConnectionRows() converts the connections to a peer to rows, one for each connection
+func peerConnectionRows(peer peerGroup, options settings) []table.Row {
+	var rows []table.Row
+
+	for _, proc := range peer.processes {
+		for _, conn := range proc.connections {
+			localPort, remotePort := conn.localPort, conn.remotePort
+			if options.serviceNames && conn.localName != "" {
+				localPort = conn.localName
+			}
+			if options.serviceNames && conn.remoteName != "" {
+				remotePort = conn.remoteName
+			}
+
+			rows = append(rows, table.Row{
+				strconv.Itoa(proc.id),
+				proc.name,
+				conn.localAddress,
+				localPort,
+				remotePort,
+				strings.ToTitle(conn.status),
+			})
+		}
+	}
+	return rows
+}
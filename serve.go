@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Server mode
+// `pvw serve` runs lsof in the background on an interval, and serves the latest results over HTTP.
+
+// The collector. Runs lsof on an interval and keeps the most recent set of processes, along with some statistics about
+// how collecting them went. Safe to use from multiple goroutines.
+type collector struct {
+	options settings // The settings lsof output is parsed with
+
+	mu          sync.RWMutex
+	processes   []process     // The processes from the most recent successful collection
+	collected   time.Time     // When the most recent successful collection finished
+	duration    time.Duration // How long the most recent collection took
+	collections uint64        // The number of times collection has been attempted
+	failures    uint64        // The number of times collection has failed
+	lastErr     error         // The error from the most recent collection, if it failed
+}
+
+// newCollector() creates a collector that parses lsof output with the given settings
+func newCollector(options settings) *collector {
+	return &collector{options: options, processes: []process{}}
+}
+
+// collect() runs lsof once and stores the result
+func (c *collector) collect() {
+	start := time.Now()
+	processes, err := collectProcesses(c.options)
+	duration := time.Since(start)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.collections += 1
+	c.duration = duration
+	c.lastErr = err
+
+	if err != nil {
+		c.failures += 1
+		return
+	}
+	c.processes = processes
+	c.collected = time.Now()
+}
+
+// run() collects straight away, then again every interval until the context is cancelled
+func (c *collector) run(ctx context.Context, interval time.Duration) {
+	c.collect()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.collect()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// latest() gets the processes from the most recent successful collection, and when they were collected
+func (c *collector) latest() ([]process, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.processes, c.collected
+}
+
+// runServe() handles `pvw serve`. Exits with 2 on errors, like the other subcommands.
+func runServe(args []string) int {
+	flags := pflag.NewFlagSet("serve", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw serve [flags]\n\nRuns lsof in the background and serves the results over HTTP.")
+		flags.PrintDefaults()
+	}
+
+	flagInterval := flags.DurationP("interval", "I", 15*time.Second, "Time between each run of lsof")
+	flagMetricsListen := flags.String("metrics-listen", "", "Address to serve Prometheus metrics on at /metrics, e.g. :9567")
+	flagDropLabels := flags.StringSlice("metrics-drop-labels", nil, "Labels to leave out of the metrics, to reduce their cardinality. Accepts a list of "+
+		"label names (process, user, state, protocol, address, port, remote_address), separated by commas.")
+	flagRemoteAddress := flags.Bool("metrics-remote-address", false, "Add a remote_address label to pvw_connections. This can create a lot of series on busy hosts")
+
+	flagAPIListen := flags.String("api-listen", "", "Address to serve the JSON API on, e.g. 127.0.0.1:9568")
+	flagAPITokenFile := flags.String("api-token-file", "", "File containing the bearer token needed to send signals through the API. Signals are disabled without one")
+	flagReadOnly := flags.BoolP("read-only", "r", false, "Read-only mode - prevents processes from being signalled through the API")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if *flagMetricsListen == "" && *flagAPIListen == "" {
+		fmt.Println("Error running pvw: nothing to serve. Set --metrics-listen, --api-listen, or both")
+		return 2
+	}
+
+	// Read the token from a file, so it doesn't show up in the process list
+	token := ""
+	if *flagAPITokenFile != "" {
+		contents, err := os.ReadFile(*flagAPITokenFile)
+		if err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+		token = strings.TrimSpace(string(contents))
+	}
+
+	if *flagInterval <= 0 {
+		fmt.Println("Error running pvw: --interval must be greater than zero")
+		return 2
+	}
+
+	for _, label := range *flagDropLabels {
+		if !isMetricLabel(label) {
+			fmt.Println("Error running pvw: unknown metrics label " + label)
+			return 2
+		}
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := newCollector(settings{showIPv4: true, showIPv6: true})
+	go c.run(ctx, *flagInterval)
+
+	var servers []*http.Server
+	errs := make(chan error, 2)
+
+	if *flagMetricsListen != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("/metrics", metricsHandler(c, metricsOptions{
+			dropLabels:    *flagDropLabels,
+			remoteAddress: *flagRemoteAddress,
+		}))
+		servers = append(servers, &http.Server{Addr: *flagMetricsListen, Handler: metrics})
+	}
+
+	if *flagAPIListen != "" {
+		servers = append(servers, &http.Server{Addr: *flagAPIListen, Handler: apiHandler(c, apiOptions{
+			readOnly: *flagReadOnly,
+			token:    token,
+		})})
+	}
+
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(server)
+		fmt.Fprintln(os.Stderr, "pvw is serving on "+server.Addr)
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		fmt.Println("Error running pvw: ", err)
+		exitCode = 2
+	}
+
+	// Give any requests in progress a few seconds to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		server.Shutdown(shutdownCtx)
+	}
+
+	return exitCode
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/pflag"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Snapshots
+// The JSON representation of the parsed lsof output, used for recordings and anything else that needs to store the
+// processes and connections outside of pvw.
+
+// The version of the recording format. Bump this whenever a change to the format would break older readers.
+const recordingVersion = 1
+
+// The value of the "format" field in a recording's header line
+const recordingFormat = "pvw-recording"
+
+// A connection, as stored in JSON. Mirrors the connection struct.
+type jsonConnection struct {
+	Protocol string `json:"protocol"`
+	Status   string `json:"status,omitempty"`
+
+	LocalAddress string `json:"localAddress"`
+	LocalPort    string `json:"localPort"`
+	LocalName    string `json:"localName,omitempty"`
+
+	RemoteAddress string `json:"remoteAddress,omitempty"`
+	RemotePort    string `json:"remotePort,omitempty"`
+	RemoteName    string `json:"remoteName,omitempty"`
+
+	IPv6 bool `json:"ipv6"`
+
+	Namespace string `json:"namespace,omitempty"`
+	Exposure  string `json:"exposure,omitempty"`
+
+	RxRate *float64 `json:"rxBytesPerSecond,omitempty"`
+	TxRate *float64 `json:"txBytesPerSecond,omitempty"`
+}
+
+// A process, as stored in JSON. Mirrors the process struct.
+type jsonProcess struct {
+	PID         int              `json:"pid"`
+	Name        string           `json:"name"`
+	User        string           `json:"user"`
+	Directory   string           `json:"directory,omitempty"`
+	Connections []jsonConnection `json:"connections"`
+
+	ContainerID   string `json:"containerId,omitempty"`
+	ContainerName string `json:"containerName,omitempty"`
+	Unit          string `json:"unit,omitempty"`
+}
+
+// A snapshot. Contains every process with a connection open at a specific time.
+type snapshot struct {
+	Time      time.Time     `json:"time"`
+	Processes []jsonProcess `json:"processes"`
+}
+
+// The first line of a recording, describing the snapshots that follow it.
+type recordingHeader struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Started  time.Time `json:"started"`
+	Interval string    `json:"interval"`
+	Hostname string    `json:"hostname,omitempty"`
+}
+
+// toJSONProcesses() converts a slice of process structs to the form stored in JSON
+func toJSONProcesses(processes []process) []jsonProcess {
+	converted := make([]jsonProcess, 0, len(processes))
+
+	for _, proc := range processes {
+		connections := make([]jsonConnection, 0, len(proc.connections))
+		for _, conn := range proc.connections {
+			// Rates are only included when they're known, so that a rate of 0 isn't confused with not tracking them
+			var rxRate, txRate *float64
+			if conn.rateKnown {
+				rx, tx := conn.rxRate, conn.txRate
+				rxRate, txRate = &rx, &tx
+			}
+
+			connections = append(connections, jsonConnection{
+				Protocol:      conn.protocol,
+				Status:        conn.status,
+				LocalAddress:  conn.localAddress,
+				LocalPort:     conn.localPort,
+				LocalName:     conn.localName,
+				RemoteAddress: conn.remoteAddress,
+				RemotePort:    conn.remotePort,
+				RemoteName:    conn.remoteName,
+				IPv6:          conn.ipv6,
+				Namespace:     conn.namespace,
+				Exposure:      string(classifyExposure(conn)),
+				RxRate:        rxRate,
+				TxRate:        txRate,
+			})
+		}
+
+		converted = append(converted, jsonProcess{
+			PID:         proc.id,
+			Name:        proc.name,
+			User:        proc.username,
+			Directory:   proc.directory,
+			Connections: connections,
+
+			ContainerID:   proc.containerID,
+			ContainerName: proc.containerName,
+			Unit:          proc.unit,
+		})
+	}
+
+	return converted
+}
+
+// fromJSONProcesses() converts processes loaded from JSON back into process structs
+func fromJSONProcesses(processes []jsonProcess) []process {
+	converted := make([]process, 0, len(processes))
+
+	for _, proc := range processes {
+		connections := make([]connection, 0, len(proc.Connections))
+		for _, conn := range proc.Connections {
+			rxRate, txRate := 0.0, 0.0
+			if conn.RxRate != nil && conn.TxRate != nil {
+				rxRate, txRate = *conn.RxRate, *conn.TxRate
+			}
+
+			connections = append(connections, connection{
+				protocol:      conn.Protocol,
+				status:        conn.Status,
+				localAddress:  conn.LocalAddress,
+				localPort:     conn.LocalPort,
+				localName:     conn.LocalName,
+				remoteAddress: conn.RemoteAddress,
+				remotePort:    conn.RemotePort,
+				remoteName:    conn.RemoteName,
+				ipv6:          conn.IPv6,
+				namespace:     conn.Namespace,
+				rxRate:        rxRate,
+				txRate:        txRate,
+				rateKnown:     conn.RxRate != nil && conn.TxRate != nil,
+			})
+		}
+
+		converted = append(converted, process{
+			id:          proc.PID,
+			name:        proc.Name,
+			username:    proc.User,
+			directory:   proc.Directory,
+			connections: connections,
+
+			containerID:   proc.ContainerID,
+			containerName: proc.ContainerName,
+			unit:          proc.Unit,
+		})
+	}
+
+	return converted
+}
+
+// writeSnapshot() writes the processes as a single indented JSON snapshot, as printed by `pvw --json`
+func writeSnapshot(out io.Writer, processes []process) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot{Time: time.Now(), Processes: toJSONProcesses(processes)})
+}
+
+// readSnapshot() reads a JSON snapshot from a file, such as one written by `pvw --json`
+func readSnapshot(path string) (snapshot, error) {
+	var snap snapshot
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("%s: not a pvw JSON snapshot: %w", path, err)
+	}
+	return snap, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Recording
+// `pvw record` writes a snapshot every interval to a JSON lines file. The first line is a recordingHeader, and every
+// line after that is a snapshot. See the README for the full format.
+
+// writeRecordingHeader() writes the header line of a recording
+func writeRecordingHeader(out io.Writer, interval time.Duration) error {
+	hostname, _ := os.Hostname()
+
+	return json.NewEncoder(out).Encode(recordingHeader{
+		Format:   recordingFormat,
+		Version:  recordingVersion,
+		Started:  time.Now(),
+		Interval: interval.String(),
+		Hostname: hostname,
+	})
+}
+
+// readRecording() reads a recording, checking the header before loading every snapshot in it
+func readRecording(in io.Reader) (recordingHeader, []snapshot, error) {
+	var header recordingHeader
+	var snapshots []snapshot
+
+	scanner := bufio.NewScanner(in)
+	// Snapshots from busy hosts can be very long lines, so don't limit them to bufio's default of 64KiB
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, err
+		}
+		return header, nil, errors.New("recording is empty")
+	}
+
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != recordingFormat {
+		return header, nil, errors.New("not a pvw recording")
+	}
+	if header.Version > recordingVersion {
+		return header, nil, fmt.Errorf("recording is version %d, but this version of pvw only supports up to version %d",
+			header.Version, recordingVersion)
+	}
+
+	line := 1
+	for scanner.Scan() {
+		line += 1
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var snap snapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snap); err != nil {
+			return header, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	return header, snapshots, scanner.Err()
+}
+
+// runRecord() handles `pvw record`. Exits with 2 on errors, like the other subcommands.
+func runRecord(args []string) int {
+	flags := pflag.NewFlagSet("record", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw record [flags]\n\nRecords snapshots of every process with a connection open to a file.\n"+
+			"Exits with 2 if something went wrong.")
+		flags.PrintDefaults()
+	}
+
+	flagInterval := flags.DurationP("interval", "I", 5*time.Second, "Time between snapshots")
+	flagOut := flags.StringP("out", "O", "pvw-recording.jsonl", "File to write the recording to")
+	flagCount := flags.Int("count", 0, "Stop after this many snapshots (0 records until interrupted)")
+	flagCwd := flags.BoolP("cwd", "d", false, "Record each process' current working directory")
+	flagThroughput := flags.Bool("throughput", false, "Record how fast each connection is receiving and sending data. Linux only")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if *flagInterval <= 0 {
+		fmt.Println("Error running pvw: --interval must be greater than zero")
+		return 2
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	file, err := os.Create(*flagOut)
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	defer file.Close()
+
+	if err := writeRecordingHeader(file, *flagInterval); err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	// Record everything, so that the filters can be picked when replaying
+	recordSettings := settings{
+		getCwd:        *flagCwd,
+		getContainers: true,
+		getUnits:      true,
+		throughput:    *flagThroughput,
+		showIPv4:      true,
+		showIPv6:      true,
+	}
+
+	// Stop cleanly on Ctrl+C so the last snapshot doesn't get cut off
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(*flagInterval)
+	defer ticker.Stop()
+
+	encoder := json.NewEncoder(file)
+	recorded := 0
+
+	for {
+		processes, err := collectProcesses(recordSettings)
+		if err != nil {
+			// A failed snapshot shouldn't end the recording, so report it and carry on
+			fmt.Fprintln(os.Stderr, "Error taking snapshot:", err)
+		} else {
+			if err := encoder.Encode(snapshot{Time: time.Now(), Processes: toJSONProcesses(processes)}); err != nil {
+				fmt.Println("Error running pvw: ", err)
+				return 2
+			}
+			recorded += 1
+			fmt.Fprintf(os.Stderr, "\rRecorded %d snapshots to %s", recorded, *flagOut)
+		}
+
+		if *flagCount > 0 && recorded >= *flagCount {
+			fmt.Fprintln(os.Stderr)
+			return 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			fmt.Fprintln(os.Stderr)
+			return 0
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Replaying
+// `pvw replay` opens the TUI on a recording, with keys to step between its snapshots.
+
+// replayFrame() renders a snapshot from a recording using the current filters and columns
+func replayFrame(snap snapshot, settingsInfo settings) tea.Cmd {
+	return func() tea.Msg {
+		parsed := filterProcesses(fromJSONProcesses(snap.Processes), settingsInfo)
+
+		formatted, ends, err := formatLsof(parsed, settingsInfo)
+		if err != nil {
+			return errMsg{err}
+		}
+
+		return processesMsg{parsed, formatted, ends, nil, nil, nil}
+	}
+}
+
+// runReplay() handles `pvw replay`. Exits with 2 on errors, like the other subcommands.
+func runReplay(args []string) int {
+	flags := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw replay [flags] FILE [process names...]\n\nOpens the TUI on a recording made with `pvw record`.\n"+
+			"Exits with 2 if something went wrong.")
+		flags.PrintDefaults()
+	}
+
+	displayOptions := addDisplayFlags(flags)
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	// The first argument is the recording, and any others act as a process name filter
+	replaySettings, err := displayOptions.settings(flags.Args()[1:])
+	if err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	_, snapshots, err := readRecording(file)
+	file.Close()
+
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("Error running pvw: recording doesn't contain any snapshots")
+		return 2
+	}
+
+	// Nothing in a recording is running any more, so terminating and refreshing are disabled
+	replaySettings.readOnly = true
+
+	if err := replaySettings.useTheme(); err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	m := newModel(replaySettings)
+	m.replay = snapshots
+	m.keys.Back.SetEnabled(true)
+	m.keys.Forward.SetEnabled(true)
+	m.keys.Refresh.SetEnabled(false)
+	m.keys.Terminate.SetEnabled(false)
+	m.keys.StopUnit.SetEnabled(false)
+	m.keys.RestartUnit.SetEnabled(false)
+
+	if _, err := tea.NewProgram(m, programOptions(replaySettings)...).Run(); err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Sorting
+// Sorts the table by any of its columns, from --sort or by clicking on a column's header.
+
+// The columns that only have a value on a process' first row. Sorting by one of these only moves processes around,
+// rather than the connections inside them too.
+var processColumns = []string{"PID", "Name", "Directory", "Owner", "Container", "Unit", "Total Rx", "Total Tx"}
+
+// The columns that sort highest first by default, as the biggest values are the interesting ones
+var descendingColumns = []string{"Rx", "Tx", "Total Rx", "Total Tx"}
+
+// The columns that need the throughput to be tracked to sort by
+var rateColumns = []string{"Rx", "Tx", "Total Rx", "Total Tx"}
+
+// The value a row is sorted by. Numbers are compared as numbers, so that port 80 comes before port 443.
+type sortKey struct {
+	number  float64
+	text    string
+	numeric bool
+}
+
+// textKey() creates a sort key for text, which is used as a number if it is one (like a port)
+func textKey(text string) sortKey {
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return sortKey{number: number, numeric: true}
+	}
+	return sortKey{text: strings.ToLower(text)}
+}
+
+// less() compares two sort keys. Numbers come before text.
+func (a sortKey) less(b sortKey) bool {
+	if a.numeric && b.numeric {
+		return a.number < b.number
+	}
+	if a.numeric != b.numeric {
+		return a.numeric
+	}
+	return a.text < b.text
+}
+
+// isSortable() checks whether the table can be sorted by a column. The History column is a picture rather than a
+// value, so it can't be.
+func isSortable(column string) bool {
+	return column != "History"
+}
+
+// connectionSortKey() gets the value of a column for a single row of the table
+func connectionSortKey(proc process, conn connection, column string) sortKey {
+	switch column {
+	case "PID":
+		return sortKey{number: float64(proc.id), numeric: true}
+	case "Name":
+		return textKey(proc.name)
+	case "Directory":
+		return textKey(proc.directory)
+	case "Owner":
+		return textKey(proc.username)
+	case "Container":
+		return textKey(containerLabel(proc))
+	case "Unit":
+		return textKey(proc.unit)
+
+	case "Protocol":
+		return textKey(conn.protocol)
+	case "Address":
+		if conn.remoteAddress != "" {
+			return textKey(conn.remoteAddress)
+		}
+		return textKey(conn.localAddress)
+	case "Port":
+		if conn.remoteAddress != "" {
+			return textKey(conn.remotePort)
+		}
+		return textKey(conn.localPort)
+	case "Local Address":
+		return textKey(conn.localAddress)
+	case "Local Port":
+		return textKey(conn.localPort)
+	case "Remote Address":
+		return textKey(conn.remoteAddress)
+	case "Remote Port":
+		return textKey(conn.remotePort)
+	case "Status":
+		return textKey(conn.status)
+	case "Namespace":
+		return textKey(conn.namespace)
+	case "Exposure":
+		return textKey(string(classifyExposure(conn)))
+
+	case "Rx":
+		return sortKey{number: conn.rxRate, numeric: true}
+	case "Tx":
+		return sortKey{number: conn.txRate, numeric: true}
+	case "Total Rx", "Total Tx":
+		rx, tx := processRate(proc)
+		if column == "Total Rx" {
+			return sortKey{number: rx, numeric: true}
+		}
+		return sortKey{number: tx, numeric: true}
+	}
+	return sortKey{}
+}
+
+// processSortKey() gets the value a process is sorted by. That's its first row's value, after its connections have
+// been sorted, except for the rate columns, where it's the total of all its connections.
+func processSortKey(proc process, column string) sortKey {
+	switch column {
+	case "Rx":
+		return connectionSortKey(proc, connection{}, "Total Rx")
+	case "Tx":
+		return connectionSortKey(proc, connection{}, "Total Tx")
+	}
+
+	if len(proc.connections) == 0 {
+		return connectionSortKey(proc, connection{}, column)
+	}
+	return connectionSortKey(proc, proc.connections[0], column)
+}
+
+// sortProcesses() sorts processes by a column, and the connections inside each process too if it's a connection
+// column. Sorting is stable, so rows with the same value stay in the order lsof listed them in.
+func sortProcesses(processes []process, column string, descending bool) {
+	if column == "" || !isSortable(column) {
+		return
+	}
+
+	ordered := func(a, b sortKey) bool {
+		if descending {
+			return b.less(a)
+		}
+		return a.less(b)
+	}
+
+	if !slices.Contains(processColumns, column) {
+		for _, proc := range processes {
+			sort.SliceStable(proc.connections, func(i, j int) bool {
+				return ordered(connectionSortKey(proc, proc.connections[i], column),
+					connectionSortKey(proc, proc.connections[j], column))
+			})
+		}
+	}
+
+	sort.SliceStable(processes, func(i, j int) bool {
+		return ordered(processSortKey(processes[i], column), processSortKey(processes[j], column))
+	})
+}
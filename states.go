@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Row colours
+// Colours each row of the table by the state of its connection, so a CLOSE_WAIT or SYN_SENT stands out in a long table.
+// bubbles' table can only style every row the same way, so the rows are coloured after the table has been rendered.
+
+// The states shown in the legend, in the order they happen in
+var stateOrder = []string{
+	"LISTEN", "SYN_SENT", "SYN_RECV", "ESTABLISHED", "FIN_WAIT1", "FIN_WAIT2", "CLOSE_WAIT", "CLOSING", "LAST_ACK",
+	"TIME_WAIT", "CLOSED", "UNCONN", "CONNECTED",
+}
+
+// The escape code that ends a style. Anything styled inside a row (like the Exposure column) ends with one.
+const styleReset = "\x1b[0m"
+
+// rowStates() gets the state of every row in a table of processes, in the order they're shown in
+func rowStates(processes []process) []string {
+	var states []string
+	for _, proc := range processes {
+		for _, conn := range proc.connections {
+			states = append(states, strings.ToUpper(conn.status))
+		}
+	}
+	return states
+}
+
+// openPeerStates() gets the state of every row in the table of connections to the peer being looked at
+func (m model) openPeerStates() []string {
+	for _, peer := range m.peers {
+		if peer.label == m.openPeer {
+			return rowStates(peer.processes)
+		}
+	}
+	return nil
+}
+
+// paint() colours a line that may already have styled text in it. The colour gets started again after every reset, so
+// it carries on after anything styled inside the line.
+func paint(line string, colour string) string {
+	marked := lipgloss.NewStyle().Foreground(lipgloss.Color(colour)).Render("x")
+	start := marked[:strings.Index(marked, "x")]
+
+	// Nothing to do when the terminal can't show colours
+	if start == "" {
+		return line
+	}
+	return start + strings.ReplaceAll(line, styleReset, styleReset+start) + styleReset
+}
+
+// colourRows() colours the rows of a rendered table by the states of their connections. The highlighted row keeps the
+// table's own style, so it can still be seen.
+func colourRows(view string, states []string, scroll tableScroll, cursor int, colours theme) string {
+	if len(colours.States) == 0 {
+		return view
+	}
+
+	// The table starts with its header and the line under it
+	lines := strings.Split(view, "\n")
+	for i := 2; i < len(lines); i++ {
+		row := scroll.offset + i - 2
+		if row >= len(states) || row == cursor {
+			continue
+		}
+
+		if colour := colours.States[states[row]]; colour != "" {
+			lines[i] = paint(lines[i], colour)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stateLegend() shows the colour of every state that has one, for the bottom of the full help
+func stateLegend(colours theme, separator lipgloss.Style) string {
+	var entries []string
+	for _, state := range stateOrder {
+		if colour := colours.States[state]; colour != "" {
+			entries = append(entries, lipgloss.NewStyle().Foreground(lipgloss.Color(colour)).Render(state))
+		}
+	}
+	return strings.Join(entries, separator.Render(" • "))
+}
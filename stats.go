@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/pflag"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Statistics
+// Adds up the connections pvw has collected in a few different ways, for the statistics screen in the TUI and
+// `pvw stats`. Answers questions like "who has the most listeners?" without having to read through the whole table.
+
+// The number of processes and peers shown in the top lists
+const statsTopCount = 10
+
+// The label used for connections without a state, like UDP sockets
+const statsNoState = "none"
+
+// Something that's been counted, like a state or a user, with how many times it came up
+type statCount struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// The statistics for a slice of processes
+type summaryStats struct {
+	Processes   int `json:"processes"`
+	Connections int `json:"connections"`
+	IPv4        int `json:"ipv4"`
+	IPv6        int `json:"ipv6"`
+
+	ByState         []statCount `json:"byState"`
+	ByProtocol      []statCount `json:"byProtocol"`
+	ListenersByUser []statCount `json:"listenersByUser"`
+	TopProcesses    []statCount `json:"topProcesses"`
+	TopPeers        []statCount `json:"topPeers"`
+}
+
+// sortedCounts() converts a map of counts into a slice with the highest counts first, ties sorted by label. Only the
+// first limit counts are kept, unless limit is 0.
+func sortedCounts(counts map[string]int, limit int) []statCount {
+	sorted := make([]statCount, 0, len(counts))
+	for label, count := range counts {
+		sorted = append(sorted, statCount{label, count})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Label < sorted[j].Label
+	})
+
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// processLabel() gets the text used for a process in the top lists
+func processLabel(proc process) string {
+	return proc.name + " (" + strconv.Itoa(proc.id) + ")"
+}
+
+// computeStats() works out the statistics for a slice of processes
+func computeStats(processes []process) summaryStats {
+	stats := summaryStats{Processes: len(processes)}
+
+	states := make(map[string]int)
+	protocols := make(map[string]int)
+	listeners := make(map[string]int)
+	perProcess := make(map[string]int)
+
+	for _, proc := range processes {
+		perProcess[processLabel(proc)] += len(proc.connections)
+
+		for _, conn := range proc.connections {
+			stats.Connections += 1
+			if conn.ipv6 {
+				stats.IPv6 += 1
+			} else {
+				stats.IPv4 += 1
+			}
+
+			state := conn.status
+			if state == "" {
+				state = statsNoState
+			}
+			states[state] += 1
+			protocols[conn.protocol] += 1
+
+			if isListening(conn) {
+				listeners[proc.username] += 1
+			}
+		}
+	}
+
+	stats.ByState = sortedCounts(states, 0)
+	stats.ByProtocol = sortedCounts(protocols, 0)
+	stats.ListenersByUser = sortedCounts(listeners, 0)
+	stats.TopProcesses = sortedCounts(perProcess, statsTopCount)
+
+	// Peers are already sorted, so just take the top of the list
+	stats.TopPeers = make([]statCount, 0, statsTopCount)
+	for _, peer := range groupPeers(processes, settings{}) {
+		if len(stats.TopPeers) == statsTopCount {
+			break
+		}
+		stats.TopPeers = append(stats.TopPeers, statCount{peer.label, peer.connections})
+	}
+	return stats
+}
+
+// formatCounts() lines up a list of counts as a column of labels and a column of numbers
+func formatCounts(counts []statCount, indent string) []string {
+	width := 0
+	for _, count := range counts {
+		if len(count.Label) > width {
+			width = len(count.Label)
+		}
+	}
+
+	lines := make([]string, 0, len(counts))
+	for _, count := range counts {
+		lines = append(lines, fmt.Sprintf("%s%-*s  %d", indent, width, count.Label, count.Count))
+	}
+	return lines
+}
+
+// totalCounts() gets the overall numbers as counts, so they can be lined up like the rest
+func (s summaryStats) totalCounts() []statCount {
+	return []statCount{
+		{"Processes", s.Processes},
+		{"Connections", s.Connections},
+		{"IPv4", s.IPv4},
+		{"IPv6", s.IPv6},
+	}
+}
+
+// writeStats() prints the statistics as plain text, one section after another
+func writeStats(w io.Writer, stats summaryStats) {
+	sections := []struct {
+		title  string
+		counts []statCount
+	}{
+		{"Totals", stats.totalCounts()},
+		{"Connections by state", stats.ByState},
+		{"Connections by protocol", stats.ByProtocol},
+		{"Listeners by user", stats.ListenersByUser},
+		{"Top processes by connections", stats.TopProcesses},
+		{"Top remote peers", stats.TopPeers},
+	}
+
+	for i, section := range sections {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintln(w, section.title)
+		if len(section.counts) == 0 {
+			fmt.Fprintln(w, "  none")
+		}
+		for _, line := range formatCounts(section.counts, "  ") {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// renderStats() draws the statistics screen for the TUI. Each section is a column, so that it all fits in about the
+// same height as the table.
+func renderStats(stats summaryStats) string {
+	title := lipgloss.NewStyle().Bold(true)
+	column := lipgloss.NewStyle().PaddingRight(4)
+
+	block := func(heading string, counts []statCount) string {
+		lines := formatCounts(counts, "")
+		if len(lines) == 0 {
+			lines = []string{"none"}
+		}
+		return title.Render(heading) + "\n" + strings.Join(lines, "\n")
+	}
+
+	return lipgloss.JoinHorizontal(lipgloss.Top,
+		column.Render(block("Totals", stats.totalCounts())+"\n\n"+block("Protocols", stats.ByProtocol)),
+		column.Render(block("States", stats.ByState)),
+		column.Render(block("Listeners by user", stats.ListenersByUser)),
+		column.Render(block("Top processes", stats.TopProcesses)),
+		block("Top remote peers", stats.TopPeers),
+	)
+}
+
+// runStats() handles `pvw stats`
+func runStats(args []string) int {
+	flags := pflag.NewFlagSet("stats", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw stats [flags] [process names...]\n\nPrints statistics about the connections pvw can see, then exits.")
+		flags.PrintDefaults()
+	}
+
+	displayOptions := addDisplayFlags(flags)
+	flagJSON := flags.Bool("json", false, "Print the statistics as JSON")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	statsSettings, err := displayOptions.settings(flags.Args())
+	if err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	processes, err := collectProcesses(statsSettings)
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	stats := computeStats(processes)
+
+	if *flagJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+		return 0
+	}
+
+	writeStats(os.Stdout, stats)
+	return 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// systemd units
+// Works out which systemd unit a process belongs to from its cgroups, and stops or restarts units with systemctl.
+
+// The suffixes of the unit types that can own processes
+var processUnitSuffixes = []string{".service", ".scope"}
+
+// Runs systemctl with the given arguments. Stored in the model so that it can be swapped out for a fake one.
+type systemctlRunner func(args ...string) error
+
+// execSystemctl() runs the real systemctl, including its output in the error if it fails
+func execSystemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return errors.New("systemctl " + strings.Join(args, " ") + ": " + strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// getSystemdUnit() gets the name of the systemd unit a process belongs to, or an empty string if it isn't in one
+func getSystemdUnit(pid int) string {
+	return unitFromCgroups(readCgroupPaths(pid))
+}
+
+// unitFromCgroups() gets the name of the systemd unit from a process' cgroup paths, as read by readCgroupPaths()
+func unitFromCgroups(paths map[string]string) string {
+	// systemd's own hierarchy is named on cgroup v1, and is the unified hierarchy on cgroup v2
+	path, exists := paths["name=systemd"]
+	if !exists {
+		path = paths[""]
+	}
+
+	// The unit is the innermost part of the path that's a unit, which skips over any cgroups a service makes for
+	// itself inside its own (like /system.slice/docker.service/some-cgroup)
+	parts := strings.Split(path, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		for _, suffix := range processUnitSuffixes {
+			if strings.HasSuffix(parts[i], suffix) {
+				return parts[i]
+			}
+		}
+	}
+	return ""
+}
+
+// matchesUnitFilter() checks whether a process is in one of the units in the unit filter. Services can be given
+// without the .service on the end, like systemctl allows.
+func matchesUnitFilter(proc process, options settings) bool {
+	if len(options.unitFilter) == 0 {
+		return true
+	}
+
+	for _, unit := range options.unitFilter {
+		if proc.unit != "" && (unit == proc.unit || unit+".service" == proc.unit) {
+			return true
+		}
+	}
+	return false
+}
+
+// unitAction() creates a command that runs a systemctl action (like stop or restart) on the unit a process is in
+func unitAction(systemctl systemctlRunner, action string, proc process) tea.Cmd {
+	return func() tea.Msg {
+		unit := proc.unit
+		if unit == "" {
+			unit = getSystemdUnit(proc.id)
+		}
+
+		// Scopes are things like login sessions, which can't be restarted, and stopping one would end the session
+		if !strings.HasSuffix(unit, ".service") {
+			return errMsg{errors.New(proc.name + " isn't part of a systemd service")}
+		}
+
+		if err := systemctl(action, unit); err != nil {
+			return errMsg{err}
+		}
+
+		// The unit's processes have changed, so get the latest slice of processes
+		return terminateMsg{}
+	}
+}
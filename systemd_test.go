@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/pflag"
+)
+
+// fakeSystemctl records the arguments systemctl is run with, instead of running it
+type fakeSystemctl struct {
+	calls [][]string
+	err   error
+}
+
+func (f *fakeSystemctl) run(args ...string) error {
+	f.calls = append(f.calls, args)
+	return f.err
+}
+
+func TestUnitFromCgroups(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths map[string]string
+		want  string
+	}{
+		{"cgroup v2 service", map[string]string{"": "/system.slice/nginx.service"}, "nginx.service"},
+		{"cgroup v1 service", map[string]string{"name=systemd": "/system.slice/sshd.service", "cpu": "/"}, "sshd.service"},
+		{"cgroup inside a service", map[string]string{"": "/system.slice/docker.service/some-cgroup"}, "docker.service"},
+		{"login session", map[string]string{"": "/user.slice/user-1000.slice/session-2.scope"}, "session-2.scope"},
+		{"no unit", map[string]string{"": "/"}, ""},
+		{"no cgroups", nil, ""},
+	}
+
+	for _, test := range tests {
+		if got := unitFromCgroups(test.paths); got != test.want {
+			t.Errorf("%s: unitFromCgroups() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchesUnitFilter(t *testing.T) {
+	proc := process{name: "nginx", unit: "nginx.service"}
+
+	tests := []struct {
+		filter []string
+		want   bool
+	}{
+		{nil, true},
+		{[]string{"nginx"}, true},
+		{[]string{"nginx.service"}, true},
+		{[]string{"postgresql", "nginx"}, true},
+		{[]string{"postgresql"}, false},
+	}
+
+	for _, test := range tests {
+		if got := matchesUnitFilter(proc, settings{unitFilter: test.filter}); got != test.want {
+			t.Errorf("matchesUnitFilter(%v) = %t, want %t", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestUnitAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		proc      process
+		err       error
+		wantCalls [][]string
+		wantErr   bool
+	}{
+		{"stops a service", process{name: "nginx", unit: "nginx.service"}, nil,
+			[][]string{{"stop", "nginx.service"}}, false},
+		{"refuses a scope", process{name: "bash", unit: "session-2.scope"}, nil, nil, true},
+		{"reports systemctl failing", process{name: "nginx", unit: "nginx.service"}, errors.New("access denied"),
+			[][]string{{"stop", "nginx.service"}}, true},
+	}
+
+	for _, test := range tests {
+		systemctl := &fakeSystemctl{err: test.err}
+		msg := unitAction(systemctl.run, "stop", test.proc)()
+
+		if !reflect.DeepEqual(systemctl.calls, test.wantCalls) {
+			t.Errorf("%s: systemctl was run with %v, want %v", test.name, systemctl.calls, test.wantCalls)
+		}
+
+		_, isErr := msg.(errMsg)
+		_, isTerminate := msg.(terminateMsg)
+		if isErr != test.wantErr || isTerminate == test.wantErr {
+			t.Errorf("%s: unitAction() returned %#v", test.name, msg)
+		}
+	}
+}
+
+// unitModel creates a model showing a single process in a systemd service, which runs systemctl through a fake
+func unitModel(t *testing.T, args ...string) (model, *fakeSystemctl) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	displayOptions := addDisplayFlags(flags)
+	if err := flags.Parse(append(args, "--no-color")); err != nil {
+		t.Fatal(err)
+	}
+	options, err := displayOptions.settings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processes := []process{{id: 1234, name: "nginx", username: "www-data", unit: "nginx.service", connections: []connection{
+		{protocol: "TCP", status: "LISTEN", localAddress: "*", localPort: "80"},
+	}}}
+	rows, ends, err := formatLsof(processes, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	systemctl := &fakeSystemctl{}
+	m := newModel(options)
+	m.systemctl = systemctl.run
+
+	updated, _ := m.Update(processesMsg{processes: processes, rows: rows, ends: ends})
+	return updated.(model), systemctl
+}
+
+func TestUnitKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		keys string
+		want [][]string
+	}{
+		{"stop", nil, "Sy", [][]string{{"stop", "nginx.service"}}},
+		{"restart", nil, "RY", [][]string{{"restart", "nginx.service"}}},
+		{"not confirmed yet", nil, "S", nil},
+		{"declined", nil, "Sn", nil},
+		{"cancelled by another key", nil, "RS", nil},
+		{"read-only", []string{"--read-only"}, "Sy", nil},
+	}
+
+	for _, test := range tests {
+		var updated tea.Model
+		m, systemctl := unitModel(t, test.args...)
+
+		for _, r := range test.keys {
+			var cmd tea.Cmd
+			updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+			m = updated.(model)
+			if cmd != nil {
+				cmd()
+			}
+		}
+
+		if !reflect.DeepEqual(systemctl.calls, test.want) {
+			t.Errorf("%s: systemctl was run with %v, want %v", test.name, systemctl.calls, test.want)
+		}
+	}
+}
+
+func TestUnitConfirmation(t *testing.T) {
+	m, _ := unitModel(t)
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("S")})
+	if want := "Stop nginx.service (pid 1234)? [y/N]"; !strings.Contains(updated.View(), want) {
+		t.Errorf("view doesn't ask %q:\n%s", want, updated.View())
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Themes
+// Every colour the TUI uses comes from a theme, picked with --theme. Colours are anything lipgloss understands: an ANSI
+// colour number like "240", or a hex colour like "#33a989". An empty colour means the terminal's own colour.
+
+// A set of colours for the TUI
+type theme struct {
+	Border string `json:"border"` // The table's border, and the line under its header
+	Header string `json:"header"` // The text of the table's header
+
+	// The highlighted row. When both are empty, the row is shown in reverse instead.
+	SelectedForeground string `json:"selectedForeground"`
+	SelectedBackground string `json:"selectedBackground"`
+
+	// The help at the bottom of the screen
+	HelpKey         string `json:"helpKey"`
+	HelpDescription string `json:"helpDescription"`
+	HelpSeparator   string `json:"helpSeparator"`
+
+	// The search bar
+	Prompt      string `json:"prompt"`
+	Placeholder string `json:"placeholder"`
+
+	Error string `json:"error"` // Errors and warnings under the table
+
+	Exposure map[exposure]string `json:"exposure"` // The Exposure column, for each level of exposure
+	States   map[string]string   `json:"states"`   // The rows of the table, for each connection state
+}
+
+// The themes built into pvw
+var themes = map[string]theme{
+	// The colours pvw has always used, which suit dark terminals best
+	"default": {
+		Border:             "240",
+		SelectedForeground: "7",
+		SelectedBackground: "#33a989",
+		HelpKey:            "#626262",
+		HelpDescription:    "#4A4A4A",
+		HelpSeparator:      "#3C3C3C",
+		Placeholder:        "240",
+		Exposure:           map[exposure]string{exposureLoopback: "2", exposureInterface: "3", exposureAll: "1"},
+		States: map[string]string{
+			"LISTEN": "2", "SYN_SENT": "6", "SYN_RECV": "6", "FIN_WAIT1": "5", "FIN_WAIT2": "5", "CLOSE_WAIT": "3",
+			"CLOSING": "5", "LAST_ACK": "5", "TIME_WAIT": "8", "CLOSED": "1", "UNCONN": "4",
+		},
+	},
+	"dark": {
+		Border:             "238",
+		Header:             "75",
+		SelectedForeground: "231",
+		SelectedBackground: "#005f87",
+		HelpKey:            "245",
+		HelpDescription:    "241",
+		HelpSeparator:      "237",
+		Prompt:             "75",
+		Placeholder:        "241",
+		Error:              "203",
+		Exposure:           map[exposure]string{exposureLoopback: "78", exposureInterface: "221", exposureAll: "203"},
+		States: map[string]string{
+			"LISTEN": "78", "SYN_SENT": "80", "SYN_RECV": "80", "FIN_WAIT1": "176", "FIN_WAIT2": "176", "CLOSE_WAIT": "221",
+			"CLOSING": "176", "LAST_ACK": "176", "TIME_WAIT": "243", "CLOSED": "203", "UNCONN": "111",
+		},
+	},
+	"light": {
+		Border:             "250",
+		Header:             "24",
+		SelectedForeground: "#000000",
+		SelectedBackground: "#a8e0d5",
+		HelpKey:            "#909090",
+		HelpDescription:    "#B2B2B2",
+		HelpSeparator:      "#DDDADA",
+		Prompt:             "24",
+		Placeholder:        "247",
+		Error:              "160",
+		Exposure:           map[exposure]string{exposureLoopback: "28", exposureInterface: "136", exposureAll: "160"},
+		States: map[string]string{
+			"LISTEN": "28", "SYN_SENT": "30", "SYN_RECV": "30", "FIN_WAIT1": "90", "FIN_WAIT2": "90", "CLOSE_WAIT": "130",
+			"CLOSING": "90", "LAST_ACK": "90", "TIME_WAIT": "245", "CLOSED": "160", "UNCONN": "25",
+		},
+	},
+	"high-contrast": {
+		Border:             "15",
+		Header:             "15",
+		SelectedForeground: "0",
+		SelectedBackground: "11",
+		HelpKey:            "15",
+		HelpDescription:    "7",
+		HelpSeparator:      "7",
+		Prompt:             "11",
+		Placeholder:        "7",
+		Error:              "9",
+		Exposure:           map[exposure]string{exposureLoopback: "10", exposureInterface: "11", exposureAll: "9"},
+		States: map[string]string{
+			"LISTEN": "10", "SYN_SENT": "14", "SYN_RECV": "14", "FIN_WAIT1": "13", "FIN_WAIT2": "13", "CLOSE_WAIT": "11",
+			"CLOSING": "13", "LAST_ACK": "13", "TIME_WAIT": "7", "CLOSED": "9", "UNCONN": "12",
+		},
+	},
+	// No colours at all, for NO_COLOR and --no-color
+	"monochrome": {},
+}
+
+// themeNames() gets the names of the built-in themes, for error messages
+func themeNames() string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// loadTheme() gets the theme to use. "auto" picks the default theme on dark terminals and the light theme on light
+// ones. Anything that isn't the name of a theme is read as a theme file. No colours are used at all when NO_COLOR is
+// set (see https://no-color.org) or noColor is true.
+func loadTheme(name string, noColor bool) (theme, error) {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return themes["monochrome"], nil
+	}
+
+	if name == "auto" {
+		if lipgloss.HasDarkBackground() {
+			return themes["default"], nil
+		}
+		return themes["light"], nil
+	}
+
+	if builtIn, exists := themes[name]; exists {
+		return builtIn, nil
+	}
+	return readTheme(name)
+}
+
+// useTheme() loads the theme named by --theme into the settings. This waits until the TUI is about to start, as "auto"
+// asks the terminal for its background colour, which would block or print junk when the output is piped somewhere.
+func (s *settings) useTheme() error {
+	colours, err := loadTheme(s.themeName, s.noColor)
+	if err != nil {
+		return err
+	}
+	s.theme = colours
+	return nil
+}
+
+// readTheme() loads a theme file. It's JSON, with the same fields as a theme, and any field that's left out comes from
+// the theme named in its "base" field (or the default theme if it doesn't have one).
+func readTheme(path string) (theme, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return theme{}, fmt.Errorf("%s isn't a theme (%s) or a theme file", path, themeNames())
+	}
+	if err != nil {
+		return theme{}, err
+	}
+
+	var base struct {
+		Base string `json:"base"`
+	}
+	if err := json.Unmarshal(data, &base); err != nil {
+		return theme{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if base.Base == "" {
+		base.Base = "default"
+	}
+
+	loaded, exists := themes[base.Base]
+	if !exists {
+		return theme{}, fmt.Errorf("%s: unknown base theme %s. Please use one of %s", path, base.Base, themeNames())
+	}
+
+	// Copy the base theme's maps, so the file doesn't change the built-in theme
+	exposures := make(map[exposure]string)
+	for level, colour := range loaded.Exposure {
+		exposures[level] = colour
+	}
+	loaded.Exposure = exposures
+
+	states := make(map[string]string)
+	for state, colour := range loaded.States {
+		states[state] = colour
+	}
+	loaded.States = states
+
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return theme{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return loaded, nil
+}
+
+// border() gets the style for the border around the table
+func (t theme) border() lipgloss.Style {
+	return lipgloss.NewStyle().
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color(t.Border))
+}
+
+// tableStyles() gets the styles for a table
+func (t theme) tableStyles() table.Styles {
+	// Change the default styles of the table
+	s := table.DefaultStyles()
+
+	s.Header = s.Header.
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(lipgloss.Color(t.Border)).
+		BorderBottom(true).
+		Foreground(lipgloss.Color(t.Header)).
+		Bold(true)
+
+	s.Selected = s.Selected.
+		Foreground(lipgloss.Color(t.SelectedForeground)).
+		Background(lipgloss.Color(t.SelectedBackground)).
+		Bold(false)
+
+	// Without any colours, the only way to show the highlighted row is to swap its colours
+	if t.SelectedForeground == "" && t.SelectedBackground == "" {
+		s.Selected = s.Selected.Reverse(true)
+	}
+	return s
+}
+
+// helpStyles() gets the styles for the help
+func (t theme) helpStyles() help.Styles {
+	keyStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(t.HelpKey))
+	descStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(t.HelpDescription))
+	sepStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(t.HelpSeparator))
+
+	return help.Styles{
+		ShortKey:       keyStyle,
+		ShortDesc:      descStyle,
+		ShortSeparator: sepStyle,
+		Ellipsis:       sepStyle.Copy(),
+		FullKey:        keyStyle.Copy(),
+		FullDesc:       descStyle.Copy(),
+		FullSeparator:  sepStyle.Copy(),
+	}
+}
+
+// styleInput() colours the search bar
+func (t theme) styleInput(input *textinput.Model) {
+	input.PromptStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(t.Prompt))
+	input.PlaceholderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(t.Placeholder))
+}
+
+// error() gets the style for errors and warnings
+func (t theme) error() lipgloss.Style {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(t.Error))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// Themes are only loaded when the TUI starts, so --json and the subcommands never ask the terminal about its colours
+func TestUseTheme(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    theme
+		wantErr bool
+	}{
+		{[]string{"--theme", "dark"}, themes["dark"], false},
+		{[]string{"--theme", "dark", "--no-color"}, themes["monochrome"], false},
+		{[]string{"--theme", "testdata/no-such-theme.json"}, theme{}, true},
+	}
+
+	for _, test := range tests {
+		t.Setenv("NO_COLOR", "")
+
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		displayOptions := addDisplayFlags(flags)
+		if err := flags.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		options, err := displayOptions.settings(nil)
+		if err != nil {
+			t.Fatalf("%v: settings() failed before the theme was used: %v", test.args, err)
+		}
+		if !reflect.DeepEqual(options.theme, theme{}) {
+			t.Errorf("%v: settings() loaded the theme", test.args)
+		}
+
+		err = options.useTheme()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: useTheme() error = %v, want an error: %t", test.args, err, test.wantErr)
+		}
+		if err == nil && !reflect.DeepEqual(options.theme, test.want) {
+			t.Errorf("%v: useTheme() loaded %+v, want %+v", test.args, options.theme, test.want)
+		}
+	}
+}
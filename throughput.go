@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Throughput
+// Works out how fast each TCP connection is moving data, from the kernel's byte counters for it. lsof doesn't have
+// these, so they come from `ss`, which means this only works on Linux.
+
+// The byte counters for a connection at a point in time
+type byteCounters struct {
+	received uint64 // tcp_info's bytes_received
+	acked    uint64 // tcp_info's bytes_acked, which is the bytes sent that actually made it to the other end
+}
+
+// The rates a connection is moving data at, in bytes per second
+type connectionRate struct {
+	rx float64
+	tx float64
+}
+
+// The throughput tracker. Keeps the byte counters from the last sample, so the rates can be worked out from the
+// difference between them and the next sample. Safe to use from multiple goroutines.
+type throughputTracker struct {
+	mu       sync.Mutex
+	previous map[string]byteCounters
+	sampled  time.Time
+	rates    map[string]connectionRate
+}
+
+// The tracker used by the TUI. There's only ever one set of connections being watched, so one tracker is enough.
+var throughput = &throughputTracker{}
+
+// checkThroughput() makes sure throughput can be tracked on this system
+func checkThroughput() error {
+	if runtime.GOOS != "linux" {
+		return errors.New("Throughput can only be shown on Linux.")
+	}
+
+	if _, err := exec.LookPath("ss"); err != nil {
+		return errors.New("ss command does not exist. Please install iproute2 with your package manager to show throughput.")
+	}
+	return nil
+}
+
+// connectionKey() identifies a connection by both of its ends, in the same form for lsof and ss. lsof and ss both put
+// brackets around IPv6 addresses, but ss writes IPv4 addresses on IPv6 sockets as ::ffff:1.2.3.4, so that's removed.
+func connectionKey(localAddress, localPort, remoteAddress, remotePort string) string {
+	normalise := func(address string) string {
+		return strings.TrimPrefix(strings.Trim(address, "[]"), "::ffff:")
+	}
+	return normalise(localAddress) + " " + localPort + " " + normalise(remoteAddress) + " " + remotePort
+}
+
+// splitSSAddress() splits an address:port from ss into its parts
+func splitSSAddress(addressAndPort string) (string, string) {
+	i := strings.LastIndex(addressAndPort, ":")
+	if i < 0 {
+		return addressAndPort, ""
+	}
+	// ss adds the interface to link-local addresses, like [fe80::1]%eth0:22
+	address := addressAndPort[:i]
+	if percent := strings.Index(address, "%"); percent >= 0 {
+		address = address[:percent]
+	}
+	return address, addressAndPort[i+1:]
+}
+
+// getByteCounters() runs ss to get the byte counters of every TCP connection, keyed by connectionKey()
+func getByteCounters() (map[string]byteCounters, error) {
+	// Command is `ss -t -i -n -H`: TCP sockets, with internal info, numeric addresses, and no header
+	out, err := exec.Command("ss", "-tinH").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]byteCounters)
+	key := ""
+
+	// Each socket is a line with its state and addresses, then an indented line with its internal info
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			// State Recv-Q Send-Q Local:Port Peer:Port
+			key = ""
+			if len(fields) >= 5 {
+				localAddress, localPort := splitSSAddress(fields[3])
+				remoteAddress, remotePort := splitSSAddress(fields[4])
+				key = connectionKey(localAddress, localPort, remoteAddress, remotePort)
+			}
+			continue
+		}
+
+		if key == "" {
+			continue
+		}
+
+		var c byteCounters
+		for _, field := range fields {
+			name, value, found := strings.Cut(field, ":")
+			if !found {
+				continue
+			}
+
+			switch name {
+			case "bytes_received":
+				c.received, _ = strconv.ParseUint(value, 10, 64)
+				break
+			case "bytes_acked":
+				c.acked, _ = strconv.ParseUint(value, 10, 64)
+				break
+			}
+		}
+		counters[key] = c
+	}
+
+	return counters, nil
+}
+
+// sample() gets the latest byte counters, and works out the rates since the last sample. The very first sample has
+// nothing to compare against, so it takes a second one shortly after.
+func (t *throughputTracker) sample() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.previous == nil {
+		counters, err := getByteCounters()
+		if err != nil {
+			return err
+		}
+		t.previous, t.sampled = counters, time.Now()
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	counters, err := getByteCounters()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	elapsed := now.Sub(t.sampled).Seconds()
+
+	t.rates = make(map[string]connectionRate)
+	for key, current := range counters {
+		// New connections are compared against zero, as that's what their counters started at
+		previous := t.previous[key]
+
+		// Counters only go down if the connection was closed and its ports reused, so start again from zero
+		if current.received < previous.received || current.acked < previous.acked {
+			previous = byteCounters{}
+		}
+
+		t.rates[key] = connectionRate{
+			rx: float64(current.received-previous.received) / elapsed,
+			tx: float64(current.acked-previous.acked) / elapsed,
+		}
+	}
+
+	t.previous, t.sampled = counters, now
+	return nil
+}
+
+// addRates() fills in the rates of every connection from the most recent sample
+func (t *throughputTracker) addRates(processes []process) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range processes {
+		for j := range processes[i].connections {
+			conn := &processes[i].connections[j]
+			rate, exists := t.rates[connectionKey(conn.localAddress, conn.localPort, conn.remoteAddress, conn.remotePort)]
+
+			conn.rateKnown = exists
+			conn.rxRate, conn.txRate = rate.rx, rate.tx
+		}
+	}
+}
+
+// processRate() adds up the rates of all of a process' connections
+func processRate(proc process) (float64, float64) {
+	rx, tx := 0.0, 0.0
+	for _, conn := range proc.connections {
+		rx += conn.rxRate
+		tx += conn.txRate
+	}
+	return rx, tx
+}
+
+// formatRate() formats a rate in bytes per second using the biggest unit that keeps it at least 1
+func formatRate(bytesPerSecond float64) string {
+	units := []string{"B/s", "KiB/s", "MiB/s", "GiB/s", "TiB/s"}
+
+	unit := 0
+	for bytesPerSecond >= 1024 && unit < len(units)-1 {
+		bytesPerSecond /= 1024
+		unit += 1
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", bytesPerSecond, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", bytesPerSecond, units[unit])
+}
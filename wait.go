@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Waiting
+// `pvw wait` runs lsof until ports are listening (or free), for scripts that need to wait for a service to start.
+
+// The exit codes for `pvw wait`
+const (
+	waitSuccess = 0
+	waitTimeout = 1
+	waitError   = 2
+)
+
+// A JUnit XML report, with a single test suite containing a single test case. Only has the parts CI systems read.
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit() writes a JUnit XML report for a wait, based on its exit code
+func writeJUnit(path, name string, elapsed time.Duration, exitCode int, output string) error {
+	seconds := strconv.FormatFloat(elapsed.Seconds(), 'f', 3, 64)
+
+	testCase := junitTestCase{Name: name, ClassName: "pvw.wait", Time: seconds, SystemOut: output}
+	suite := junitTestSuite{Name: "pvw wait", Tests: 1, Time: seconds}
+
+	switch exitCode {
+	case waitTimeout:
+		testCase.Failure = &junitFailure{Message: "timed out", Text: output}
+		suite.Failures = 1
+		break
+	case waitError:
+		testCase.Error = &junitFailure{Message: "error", Text: output}
+		suite.Errors = 1
+		break
+	}
+	suite.Cases = []junitTestCase{testCase}
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+// portOwners() describes every process using a local port, one per line
+func portOwners(processes []process, port string) []string {
+	var owners []string
+
+	for _, proc := range selectConnections(processes, func(proc process, conn connection) bool {
+		return conn.localPort == port
+	}) {
+		for _, conn := range proc.connections {
+			owners = append(owners, fmt.Sprintf("%s %s:%s %s %s (pid %d, user %s)", conn.protocol, conn.localAddress,
+				conn.localPort, strings.ToTitle(conn.status), proc.name, proc.id, proc.username))
+		}
+	}
+
+	return owners
+}
+
+// waitConditionMet() checks whether every port is listening (owned by the right process, if one is given), or whether
+// every port is free
+func waitConditionMet(processes []process, ports []string, free bool, processName string) bool {
+	for _, port := range ports {
+		listening := false
+		used := false
+
+		for _, proc := range processes {
+			for _, conn := range proc.connections {
+				if conn.localPort != port {
+					continue
+				}
+				used = true
+				if isListening(conn) && (processName == "" || proc.name == processName) {
+					listening = true
+				}
+			}
+		}
+
+		if (free && used) || (!free && !listening) {
+			return false
+		}
+	}
+	return true
+}
+
+// runWait() handles `pvw wait`
+func runWait(args []string) int {
+	flags := pflag.NewFlagSet("wait", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw wait [flags]\n\nWaits until ports are listening, or until they're free.\n"+
+			"Exits with 0 when they are, 1 on timeout, and 2 if something went wrong.")
+		flags.PrintDefaults()
+	}
+
+	flagListen := flags.StringSlice("listen", nil, "Wait until all of these ports are listening")
+	flagFree := flags.StringSlice("free", nil, "Wait until nothing is using any of these ports")
+	flagProcess := flags.String("process", "", "Only count ports listened on by processes with this name")
+	flagTimeout := flags.DurationP("timeout", "t", 60*time.Second, "Give up after this long (0 waits forever)")
+	flagInterval := flags.DurationP("interval", "I", 500*time.Millisecond, "Time between each run of lsof")
+	flagJUnit := flags.String("junit", "", "Write a JUnit XML report to this file")
+	flagQuiet := flags.BoolP("quiet", "q", false, "Don't print anything, only set the exit code")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return waitSuccess
+		}
+		return waitError
+	}
+
+	if (len(*flagListen) == 0) == (len(*flagFree) == 0) {
+		fmt.Println("Error running pvw: set exactly one of --listen or --free")
+		return waitError
+	}
+
+	free := len(*flagFree) > 0
+	ports := *flagListen
+	name := "listen " + strings.Join(ports, ",")
+	waitingFor := "port " + strings.Join(ports, ", ") + " to listen"
+	if free {
+		ports = *flagFree
+		name = "free " + strings.Join(ports, ",")
+		waitingFor = "port " + strings.Join(ports, ", ") + " to be free"
+	}
+
+	for _, port := range ports {
+		if _, err := strconv.Atoi(port); err != nil {
+			fmt.Println("Error running pvw: invalid port " + port)
+			return waitError
+		}
+	}
+
+	if *flagInterval <= 0 {
+		fmt.Println("Error running pvw: --interval must be greater than zero")
+		return waitError
+	}
+
+	// Keep the output so it can go in the JUnit report too
+	var output strings.Builder
+	start := time.Now()
+
+	exitCode := func() int {
+		if err := checkPlatform(); err != nil {
+			output.WriteString("Error running pvw: " + err.Error() + "\n")
+			return waitError
+		}
+
+		waitSettings := settings{showIPv4: true, showIPv6: true}
+
+		for {
+			processes, err := collectProcesses(waitSettings)
+			if err != nil {
+				output.WriteString("Error running lsof: " + err.Error() + "\n")
+				return waitError
+			}
+
+			if waitConditionMet(processes, ports, free, *flagProcess) {
+				for _, port := range ports {
+					if free {
+						output.WriteString("Port " + port + " is free\n")
+						continue
+					}
+					for _, owner := range portOwners(processes, port) {
+						output.WriteString(owner + "\n")
+					}
+				}
+				return waitSuccess
+			}
+
+			if *flagTimeout > 0 && time.Since(start) >= *flagTimeout {
+				output.WriteString(fmt.Sprintf("Timed out after %s waiting for %s\n", *flagTimeout, waitingFor))
+				for _, port := range ports {
+					for _, owner := range portOwners(processes, port) {
+						output.WriteString(owner + "\n")
+					}
+				}
+				return waitTimeout
+			}
+
+			time.Sleep(*flagInterval)
+		}
+	}()
+
+	if !*flagQuiet {
+		fmt.Print(output.String())
+	}
+
+	if *flagJUnit != "" {
+		if err := writeJUnit(*flagJUnit, name, time.Since(start), exitCode, output.String()); err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return waitError
+		}
+	}
+
+	return exitCode
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Watching
+// `pvw watch` runs lsof on an interval and fires hooks when the rules it's been given match.
+
+// The events a rule can fire on
+const (
+	eventListen      = "listen"      // A port started listening
+	eventClose       = "close"       // A port stopped listening
+	eventConnections = "connections" // A process has more established connections than allowed
+	eventUser        = "user"        // A port started listening, owned by a user that isn't allowed
+)
+
+// A watch rule. Fires its hook whenever its event happens to a port or process that matches it.
+type watchRule struct {
+	Event   string   `json:"event"`             // One of the event constants
+	Port    string   `json:"port,omitempty"`    // Only fire for (or count connections on) this port. Empty matches every port
+	Process string   `json:"process,omitempty"` // Only fire for processes with this name. Empty matches every process
+	Max     int      `json:"max,omitempty"`     // The number of established connections allowed, for connections rules
+	Users   []string `json:"users,omitempty"`   // The users allowed to listen, for user rules
+	Exec    string   `json:"exec,omitempty"`    // The hook to run. Prints the event instead if empty
+}
+
+// A rules file, as loaded from JSON.
+type watchRules struct {
+	Interval string      `json:"interval,omitempty"`
+	Debounce string      `json:"debounce,omitempty"`
+	Rules    []watchRule `json:"rules"`
+}
+
+// An event that's been fired by a rule.
+type watchEvent struct {
+	event    string
+	pid      int
+	process  string
+	user     string
+	protocol string
+	address  string
+	port     string
+	count    int
+}
+
+// fields() gets the values that can be used in a hook, by name
+func (e watchEvent) fields() map[string]string {
+	return map[string]string{
+		"event":    e.event,
+		"pid":      strconv.Itoa(e.pid),
+		"process":  e.process,
+		"user":     e.user,
+		"protocol": e.protocol,
+		"address":  e.address,
+		"port":     e.port,
+		"count":    strconv.Itoa(e.count),
+	}
+}
+
+// String() describes the event in a single line
+func (e watchEvent) String() string {
+	switch e.event {
+	case eventConnections:
+		if e.port != "" {
+			return fmt.Sprintf("%s: %s (pid %d, user %s) has %d established connections on port %s", e.event, e.process,
+				e.pid, e.user, e.count, e.port)
+		}
+		return fmt.Sprintf("%s: %s (pid %d, user %s) has %d established connections", e.event, e.process, e.pid, e.user, e.count)
+	default:
+		return fmt.Sprintf("%s: %s %s:%s %s (pid %d, user %s)", e.event, e.protocol, e.address, e.port, e.process, e.pid, e.user)
+	}
+}
+
+// validate() checks that a rule makes sense
+func (r watchRule) validate() error {
+	switch r.Event {
+	case eventListen, eventClose:
+		break
+	case eventConnections:
+		if r.Max < 1 {
+			return errors.New("connections rules need a max of at least 1")
+		}
+		break
+	case eventUser:
+		if len(r.Users) == 0 {
+			return errors.New("user rules need a list of allowed users")
+		}
+		break
+	default:
+		return fmt.Errorf("unknown event %q", r.Event)
+	}
+
+	if r.Port != "" {
+		if _, err := strconv.Atoi(r.Port); err != nil {
+			return fmt.Errorf("invalid port %q", r.Port)
+		}
+	}
+	return nil
+}
+
+// matches() checks whether a rule applies to a process and port
+func (r watchRule) matches(process, port string) bool {
+	return (r.Port == "" || r.Port == port) && (r.Process == "" || r.Process == process)
+}
+
+// splitCommand() splits a hook into its arguments like a shell would, handling single and double quotes
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, char := range command {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(char)
+		case char == '\'' || char == '"':
+			quote = char
+			inArg = true
+		case char == ' ' || char == '\t' || char == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in " + command)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// runHook() runs a rule's hook for an event. Each {field} in the arguments is replaced with the event's value, and the
+// values are also passed in PVW_FIELD environment variables. The hook runs in the background so a slow one doesn't
+// hold up the next check.
+func runHook(rule watchRule, event watchEvent) {
+	if rule.Exec == "" {
+		fmt.Println(time.Now().Format("2006-01-02 15:04:05") + " " + event.String())
+		return
+	}
+
+	args, err := splitCommand(rule.Exec)
+	if err != nil || len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Error running hook: ", err)
+		return
+	}
+
+	fields := event.fields()
+	env := os.Environ()
+	for name, value := range fields {
+		env = append(env, "PVW_"+strings.ToUpper(name)+"="+value)
+	}
+
+	// Replace fields in each argument separately, so values with spaces in them stay as a single argument
+	for i, arg := range args {
+		for name, value := range fields {
+			arg = strings.ReplaceAll(arg, "{"+name+"}", value)
+		}
+		args[i] = arg
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	go func() {
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error running hook "+args[0]+": ", err)
+		}
+	}()
+}
+
+// The watcher. Compares each set of processes with the one before it to find events.
+type watcher struct {
+	rules    []watchRule
+	debounce time.Duration // How long to wait before firing the same rule for the same port or process again
+	initial  bool          // Whether to fire listen and user events for ports that were already listening when pvw started
+
+	previous map[string]diffListener // The listeners from the last check. nil before the first check
+	fired    map[string]time.Time    // When each rule last fired for each port or process, for debouncing
+	above    map[string]bool         // Which processes are over the limit of each connections rule
+}
+
+// fire() runs a rule's hook, unless it already fired for the same thing within the debounce time
+func (w *watcher) fire(ruleIndex int, key string, event watchEvent) {
+	id := strconv.Itoa(ruleIndex) + " " + key
+	if last, exists := w.fired[id]; exists && time.Since(last) < w.debounce {
+		return
+	}
+	w.fired[id] = time.Now()
+
+	runHook(w.rules[ruleIndex], event)
+}
+
+// check() looks for events in a new set of processes
+func (w *watcher) check(processes []process) {
+	listeners := snapshotListeners(snapshot{Processes: toJSONProcesses(processes)})
+	listenerEvent := func(event string, l diffListener) watchEvent {
+		return watchEvent{event: event, pid: l.PID, process: l.Process, user: l.User,
+			protocol: l.Protocol, address: l.Address, port: l.Port}
+	}
+
+	for i, rule := range w.rules {
+		switch rule.Event {
+		case eventListen:
+			// Don't treat everything as new on the first check, unless asked to
+			if w.previous == nil && !w.initial {
+				break
+			}
+			for key, l := range listeners {
+				if _, existed := w.previous[key]; !existed && rule.matches(l.Process, l.Port) {
+					w.fire(i, key, listenerEvent(eventListen, l))
+				}
+			}
+			break
+
+		case eventClose:
+			for key, l := range w.previous {
+				if _, exists := listeners[key]; !exists && rule.matches(l.Process, l.Port) {
+					w.fire(i, key, listenerEvent(eventClose, l))
+				}
+			}
+			break
+
+		case eventUser:
+			// Like listen rules, ports that were already listening when pvw started only count when asked to
+			if w.previous == nil && !w.initial {
+				break
+			}
+			for key, l := range listeners {
+				_, existed := w.previous[key]
+				if !existed && rule.matches(l.Process, l.Port) && !slices.Contains(rule.Users, l.User) {
+					w.fire(i, key, listenerEvent(eventUser, l))
+				}
+			}
+			break
+
+		case eventConnections:
+			current := make(map[string]bool)
+			for _, proc := range processes {
+				if rule.Process != "" && rule.Process != proc.name {
+					continue
+				}
+
+				// With a port, only the connections on that local port (like a server's clients) count
+				established := 0
+				for _, conn := range proc.connections {
+					if conn.status == "ESTABLISHED" && rule.matches(proc.name, conn.localPort) {
+						established += 1
+					}
+				}
+
+				// Only fire when a process goes over the limit, not on every check while it stays over it
+				id := strconv.Itoa(i) + " " + strconv.Itoa(proc.id)
+				if established > rule.Max && !w.above[id] {
+					w.fire(i, strconv.Itoa(proc.id), watchEvent{event: eventConnections, pid: proc.id, process: proc.name,
+						user: proc.username, port: rule.Port, count: established})
+				}
+				w.above[id] = established > rule.Max
+				current[id] = true
+			}
+
+			// Forget processes that have gone
+			prefix := strconv.Itoa(i) + " "
+			for id := range w.above {
+				if strings.HasPrefix(id, prefix) && !current[id] {
+					delete(w.above, id)
+				}
+			}
+			break
+		}
+	}
+
+	// Forget when rules fired once the debounce time has passed, as they can't hold anything back any more. This keeps
+	// a watcher that runs for a long time from holding on to every listener that's ever come and gone.
+	for id, last := range w.fired {
+		if time.Since(last) >= w.debounce {
+			delete(w.fired, id)
+		}
+	}
+
+	w.previous = listeners
+}
+
+// runWatch() handles `pvw watch`
+func runWatch(args []string) int {
+	flags := pflag.NewFlagSet("watch", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw watch [flags]\n\nRuns lsof on an interval, and runs a hook whenever a rule matches.\n"+
+			"Hooks can use {event}, {pid}, {process}, {user}, {protocol}, {address}, {port} and {count} in their\n"+
+			"arguments, which are also passed in PVW_EVENT, PVW_PID, etc. environment variables.")
+		flags.PrintDefaults()
+	}
+
+	flagRules := flags.String("rules", "", "JSON file of rules to watch for")
+	flagOnListen := flags.StringSlice("on-listen", nil, "Fire when any of these ports start listening. Use * for any port")
+	flagOnClose := flags.StringSlice("on-close", nil, "Fire when any of these ports stop listening. Use * for any port")
+	flagMaxConnections := flags.Int("max-connections", 0, "Fire when a process has more than this many established connections")
+	flagAllowedUsers := flags.StringSlice("allowed-users", nil, "Fire when a port starts listening, owned by a user not in this list")
+	flagProcess := flags.String("process", "", "Only fire for processes with this name")
+	flagExec := flags.String("exec", "", "Hook to run when a rule fires. Prints the event if not set")
+	flagInterval := flags.DurationP("interval", "I", 2*time.Second, "Time between each run of lsof")
+	flagDebounce := flags.Duration("debounce", 30*time.Second, "Don't fire the same rule for the same port or process more than once in this time")
+	flagInitial := flags.Bool("initial", false, "Fire listen and allowed-users rules for ports that are already listening when pvw starts")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	interval, debounce := *flagInterval, *flagDebounce
+	var rules []watchRule
+
+	if *flagRules != "" {
+		data, err := os.ReadFile(*flagRules)
+		if err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+
+		var file watchRules
+		if err := json.Unmarshal(data, &file); err != nil {
+			fmt.Println("Error running pvw: "+*flagRules+": ", err)
+			return 2
+		}
+
+		// Settings in the rules file are overridden by flags
+		if file.Interval != "" && !flags.Changed("interval") {
+			if interval, err = time.ParseDuration(file.Interval); err != nil {
+				fmt.Println("Error running pvw: "+*flagRules+": ", err)
+				return 2
+			}
+		}
+		if file.Debounce != "" && !flags.Changed("debounce") {
+			if debounce, err = time.ParseDuration(file.Debounce); err != nil {
+				fmt.Println("Error running pvw: "+*flagRules+": ", err)
+				return 2
+			}
+		}
+		rules = append(rules, file.Rules...)
+	}
+
+	// Rules from flags all share the same process filter and hook
+	portRules := func(event string, ports []string) {
+		for _, port := range ports {
+			if port == "*" {
+				port = ""
+			}
+			rules = append(rules, watchRule{Event: event, Port: port, Process: *flagProcess, Exec: *flagExec})
+		}
+	}
+	portRules(eventListen, *flagOnListen)
+	portRules(eventClose, *flagOnClose)
+	if *flagMaxConnections > 0 {
+		rules = append(rules, watchRule{Event: eventConnections, Max: *flagMaxConnections, Process: *flagProcess, Exec: *flagExec})
+	}
+	if len(*flagAllowedUsers) > 0 {
+		rules = append(rules, watchRule{Event: eventUser, Users: *flagAllowedUsers, Process: *flagProcess, Exec: *flagExec})
+	}
+
+	if len(rules) == 0 {
+		fmt.Println("Error running pvw: no rules to watch for. Use --rules or one of the --on-* flags")
+		return 2
+	}
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			fmt.Printf("Error running pvw: rule %d: %s\n", i+1, err)
+			return 2
+		}
+	}
+
+	if interval <= 0 {
+		fmt.Println("Error running pvw: --interval must be greater than zero")
+		return 2
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := &watcher{
+		rules:    rules,
+		debounce: debounce,
+		initial:  *flagInitial,
+		fired:    make(map[string]time.Time),
+		above:    make(map[string]bool),
+	}
+	watchSettings := settings{showIPv4: true, showIPv6: true}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		processes, err := collectProcesses(watchSettings)
+		if err != nil {
+			// Keep watching, as the next run of lsof may well work
+			fmt.Fprintln(os.Stderr, "Error running lsof: ", err)
+		} else {
+			w.check(processes)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestWatcher() creates a watcher for some rules, that never fires the same thing twice while a test runs
+func newTestWatcher(rules ...watchRule) *watcher {
+	return &watcher{
+		rules:    rules,
+		debounce: time.Hour,
+		fired:    make(map[string]time.Time),
+		above:    make(map[string]bool),
+	}
+}
+
+// established() creates a process with established connections on each of the given local ports
+func established(pid int, name string, ports ...string) process {
+	proc := process{id: pid, name: name, username: "root"}
+	for _, port := range ports {
+		proc.connections = append(proc.connections, connection{protocol: "TCP", status: "ESTABLISHED",
+			localAddress: "10.0.0.1", localPort: port, remoteAddress: "10.0.0.2", remotePort: "50000"})
+	}
+	return proc
+}
+
+func TestWatchConnections(t *testing.T) {
+	processes := []process{
+		established(100, "nginx", "443", "443", "443", "80"),
+		established(200, "postgres", "5432", "5432"),
+	}
+
+	tests := []struct {
+		name string
+		rule watchRule
+		want []string // The PIDs the rule should fire for
+	}{
+		{"any port", watchRule{Event: eventConnections, Max: 2}, []string{"100"}},
+		{"any port, higher max", watchRule{Event: eventConnections, Max: 3}, []string{"100"}},
+		{"matching port", watchRule{Event: eventConnections, Port: "443", Max: 2}, []string{"100"}},
+		{"port under the max", watchRule{Event: eventConnections, Port: "443", Max: 3}, nil},
+		{"other process' port", watchRule{Event: eventConnections, Port: "5432", Max: 1}, []string{"200"}},
+		{"port and process", watchRule{Event: eventConnections, Port: "5432", Process: "nginx", Max: 1}, nil},
+	}
+
+	for _, test := range tests {
+		w := newTestWatcher(test.rule)
+		w.check(processes)
+
+		if len(w.fired) != len(test.want) {
+			t.Errorf("%s: fired %v, want %v", test.name, w.fired, test.want)
+			continue
+		}
+		for _, pid := range test.want {
+			if _, fired := w.fired["0 "+pid]; !fired {
+				t.Errorf("%s: didn't fire for %s, fired %v", test.name, pid, w.fired)
+			}
+		}
+	}
+}
+
+func TestWatchRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule    watchRule
+		wantErr bool
+	}{
+		{watchRule{Event: eventListen, Port: "8080"}, false},
+		{watchRule{Event: eventConnections, Port: "443", Max: 100}, false},
+		{watchRule{Event: eventConnections}, true},
+		{watchRule{Event: eventUser}, true},
+		{watchRule{Event: eventListen, Port: "http"}, true},
+		{watchRule{Event: "open"}, true},
+	}
+
+	for _, test := range tests {
+		if err := test.rule.validate(); (err != nil) != test.wantErr {
+			t.Errorf("validate(%+v) = %v, want an error: %t", test.rule, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+)
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// Port lookup
+// `pvw who` prints everything about the processes using a port, without opening the TUI.
+
+// The port for each service name in serviceNames. Some names are used for more than one port, and they always get the
+// lowest one, so looking a name up gives the same port every time.
+var servicePorts = indexServicePorts()
+
+// indexServicePorts() builds the servicePorts index
+func indexServicePorts() map[string]string {
+	ports := make(map[string]string)
+	lowest := make(map[string]int)
+
+	for port, service := range serviceNames {
+		number, err := strconv.Atoi(port)
+		if err != nil {
+			continue
+		}
+		if current, exists := lowest[service]; !exists || number < current {
+			lowest[service] = number
+			ports[service] = port
+		}
+	}
+	return ports
+}
+
+// lookupServicePort() converts a service name to a port number, using the serviceNames table and then the system's
+// services database
+func lookupServicePort(name string) (string, error) {
+	if port, exists := servicePorts[name]; exists {
+		return port, nil
+	}
+
+	port, err := net.LookupPort("tcp", name)
+	if err != nil {
+		return "", fmt.Errorf("unknown port or service %q", name)
+	}
+	return strconv.Itoa(port), nil
+}
+
+// parsePortSpec() splits a port given on the command line into the addresses and port to look for. It accepts a port
+// number, a service name, or either of those with a host in front, like `localhost:8080` or `[::1]:http`. No addresses
+// are returned if there isn't a host.
+func parsePortSpec(spec string) ([]string, string, error) {
+	host, port := "", spec
+	if strings.Contains(spec, ":") {
+		var err error
+		if host, port, err = net.SplitHostPort(spec); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if _, err := strconv.Atoi(port); err != nil {
+		if port, err = lookupServicePort(port); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if host == "" {
+		return nil, port, nil
+	}
+
+	addresses, err := net.LookupHost(host)
+	if err != nil {
+		return nil, "", err
+	}
+	return addresses, port, nil
+}
+
+// isWildcardAddress() checks whether a local address means every interface
+func isWildcardAddress(address string) bool {
+	return address == "*" || address == "0.0.0.0" || address == "[::]" || address == "::"
+}
+
+// addressMatches() checks whether a local address is one of the addresses given, treating wildcards as a match for
+// everything. lsof puts brackets around IPv6 addresses, so they're removed before comparing.
+func addressMatches(address string, addresses []string) bool {
+	if addresses == nil || isWildcardAddress(address) {
+		return true
+	}
+	return slices.Contains(addresses, strings.Trim(address, "[]"))
+}
+
+// getCommandLine() gets the full command line of a process from a PID
+func getCommandLine(pid int) string {
+	// Command is `ps -o command= -p PID`
+	out, err := exec.Command("ps", "-o", "command=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// getWorkingDirectory() gets the current working directory of a process from a PID
+func getWorkingDirectory(pid int) string {
+	// Command is `lsof -a -p PID -d cwd -F n`, which prints the PID then the directory on a line starting with n
+	out, err := exec.Command("lsof", "-a", "-p", strconv.Itoa(pid), "-d", "cwd", "-F", "n").Output()
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "n") {
+			return line[1:]
+		}
+	}
+	return ""
+}
+
+// confirmPrompt() adds the choices to a yes or no question, so the terminal and the TUI ask the same way
+func confirmPrompt(question string) string {
+	return question + " [y/N] "
+}
+
+// confirmed() checks whether the answer to a yes or no question is yes. Anything else, even nothing, is no.
+func confirmed(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// confirm() asks a yes or no question on the terminal, defaulting to no
+func confirm(reader *bufio.Reader, question string) bool {
+	fmt.Print(confirmPrompt(question))
+
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return confirmed(answer)
+}
+
+// runWho() handles `pvw who`
+func runWho(args []string) int {
+	flags := pflag.NewFlagSet("who", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: pvw who [flags] PORT...\n\nShows the processes using a port. Ports can be numbers, service names,\n"+
+			"or either of those with a host, like localhost:8080. Exits with 1 if nothing is using them.")
+		flags.PrintDefaults()
+	}
+
+	flagKill := flags.BoolP("kill", "k", false, "Terminate the processes using the port, after asking for confirmation")
+	flagYes := flags.BoolP("yes", "y", false, "Don't ask for confirmation before terminating processes")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	type portSpec struct {
+		addresses []string
+		port      string
+	}
+	var specs []portSpec
+
+	for _, arg := range flags.Args() {
+		addresses, port, err := parsePortSpec(arg)
+		if err != nil {
+			fmt.Println("Error running pvw: ", err)
+			return 2
+		}
+		specs = append(specs, portSpec{addresses, port})
+	}
+
+	if err := checkPlatform(); err != nil {
+		fmt.Println("Error running pvw: " + err.Error())
+		return 2
+	}
+
+	processes, err := collectProcesses(settings{showIPv4: true, showIPv6: true})
+	if err != nil {
+		fmt.Println("Error running pvw: ", err)
+		return 2
+	}
+
+	matching := selectConnections(processes, func(proc process, conn connection) bool {
+		for _, spec := range specs {
+			if conn.localPort == spec.port && addressMatches(conn.localAddress, spec.addresses) {
+				return true
+			}
+		}
+		return false
+	})
+
+	if len(matching) == 0 {
+		fmt.Println("Nothing is using " + strings.Join(flags.Args(), ", "))
+		return 1
+	}
+
+	for i, proc := range matching {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("PID      %d\n", proc.id)
+		fmt.Printf("Name     %s\n", proc.name)
+		fmt.Printf("User     %s\n", proc.username)
+		fmt.Printf("Command  %s\n", getCommandLine(proc.id))
+		fmt.Printf("Cwd      %s\n", getWorkingDirectory(proc.id))
+
+		for _, conn := range proc.connections {
+			socket := conn.protocol + " " + conn.localAddress + ":" + conn.localPort
+			if conn.remoteAddress != "" {
+				socket += "->" + conn.remoteAddress + ":" + conn.remotePort
+			}
+			fmt.Printf("Socket   %s %s\n", socket, strings.ToTitle(conn.status))
+		}
+	}
+
+	if *flagKill {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Println()
+
+		for _, proc := range matching {
+			if !*flagYes && !confirm(reader, fmt.Sprintf("Terminate %s (pid %d)?", proc.name, proc.id)) {
+				continue
+			}
+
+			if err := signalProcess(proc.id, ""); err != nil {
+				fmt.Printf("Error terminating %s (pid %d): %s\n", proc.name, proc.id, err)
+				return 2
+			}
+			fmt.Printf("Terminated %s (pid %d)\n", proc.name, proc.id)
+		}
+	}
+
+	return 0
+}
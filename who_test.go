@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestLookupServicePort(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"ssh", "22"},
+		{"irc", "194"},           // Also 6665 and 6669
+		{"kaspersky-av", "8086"}, // Also 8087
+	}
+
+	for _, test := range tests {
+		// Run each lookup a few times, as map order changes between runs
+		for i := 0; i < 10; i++ {
+			got, err := lookupServicePort(test.name)
+			if err != nil {
+				t.Fatalf("lookupServicePort(%q): %v", test.name, err)
+			}
+			if got != test.want {
+				t.Fatalf("lookupServicePort(%q) = %s, want %s", test.name, got, test.want)
+			}
+		}
+	}
+}